@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type adminHandler struct {
+	tgbotbase.BaseHandler
+
+	admins map[int]bool
+	pics   *PicCache
+}
+
+var _ tgbotbase.IncomingMessageHandler = &adminHandler{}
+
+// NewAdminHandler serves commands showing internals of the bot, they are answered only to the admins
+func NewAdminHandler(admins []int, pics *PicCache) tgbotbase.IncomingMessageHandler {
+	h := &adminHandler{
+		admins: make(map[int]bool, len(admins)),
+		pics:   pics,
+	}
+	for _, a := range admins {
+		h.admins[a] = true
+	}
+	return h
+}
+
+func (h *adminHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *adminHandler) trigger() (*regexp.Regexp, []string) {
+	return nil, []string{"cachestats"}
+}
+
+func (h *adminHandler) HandleOne(msg tgbotapi.Message) {
+	if msg.From == nil || !h.admins[msg.From.ID] {
+		log.WithFields(log.Fields{"chat": msg.Chat.ID, "cmd": msg.Command()}).Warn("admin command from not an admin")
+		return
+	}
+
+	var text string
+	switch msg.Command() {
+	case "cachestats":
+		text = formatPicCacheStats(h.pics.Stats())
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func formatPicCacheStats(s PicCacheStats) string {
+	rate := 0.0
+	if total := s.Hits + s.Misses; total > 0 {
+		rate = float64(s.Hits) * 100 / float64(total)
+	}
+	return fmt.Sprintf("Picture cache: %d pictures, %.1f MB\nHits: %d, misses: %d, hit rate %.1f%%\nEvictions: %d",
+		s.Count, float64(s.Size)/1024/1024, s.Hits, s.Misses, rate, s.Evictions)
+}
+
+func (h *adminHandler) Name() string {
+	return "admin"
+}
@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultAutumnMagicURL = "https://autumnsmagic.com"
+
+	// autumnMagicTrader is how mtgbulk names the store, its offers are replaced by ours
+	autumnMagicTrader = "AutumnsMagic"
+	// autumnMagicFoilMarker is the label the store puts into foil products, names and set descriptions
+	// of other products may mention foil as well
+	autumnMagicFoilMarker = ".product-foil"
+)
+
+// autumnMagic scrapes search results of autumnsmagic.com store
+type autumnMagic struct {
+	baseURL string
+}
+
+var _ PriceProvider = &autumnMagic{}
+
+func newAutumnMagic(baseURL string) *autumnMagic {
+	return &autumnMagic{baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (a *autumnMagic) searchURL(name string) string {
+	return a.baseURL + "/catalog?search=" + url.QueryEscape(strings.ToLower(name))
+}
+
+func (a *autumnMagic) Name() string {
+	return autumnMagicProviderName
+}
+
+// Key is the english card name, it is used for search
+func (a *autumnMagic) Key(c carddb.Card) string {
+	return strings.ToLower(c.Name)
+}
+
+// Offers returns every offer of the card found at the store
+func (a *autumnMagic) Offers(ctx context.Context, c carddb.Card) ([]Offer, error) {
+	names := make(map[string]bool)
+	for _, n := range []string{c.Name, c.LocalName} {
+		names[strings.ToLower(n)] = true
+		for _, face := range strings.Split(n, " // ") {
+			names[strings.ToLower(face)] = true
+		}
+	}
+
+	offers := []Offer{}
+	addr := a.searchURL(c.Name)
+	coll := colly.NewCollector()
+	timeout := 20 * time.Second
+	if deadline, found := ctx.Deadline(); found {
+		timeout = time.Until(deadline)
+	}
+	coll.SetRequestTimeout(timeout)
+	coll.OnHTML(".product-wrapper", func(e *colly.HTMLElement) {
+		name := strings.TrimSpace(e.ChildText(".card-name a"))
+		if !names[strings.ToLower(name)] {
+			return
+		}
+		qty, err := parseStoreNumber(e.ChildText(".product-description span"))
+		if err != nil || qty == 0 {
+			log.WithFields(log.Fields{"name": name, "err": err}).Debug("autumnmagic offer without quantity")
+			return
+		}
+		price, err := parseStoreNumber(e.ChildText(".product-price span.product-default-price"))
+		if err != nil {
+			log.WithFields(log.Fields{"name": name, "err": err}).Error("cannot parse autumnmagic price")
+			return
+		}
+		link := addr
+		if href := e.ChildAttr(".card-name a", "href"); href != "" {
+			link = e.Request.AbsoluteURL(href)
+		}
+		foil := e.DOM.Find(autumnMagicFoilMarker).Length() > 0
+		offers = append(offers, Offer{
+			Source:   autumnMagicProviderName,
+			Seller:   autumnMagicTrader,
+			Price:    float64(price),
+			Currency: currencyRUB,
+			Foil:     foil,
+			Quantity: qty,
+			URL:      link,
+		})
+	})
+
+	if err := coll.Visit(addr); err != nil {
+		log.WithFields(log.Fields{"url": addr, "err": err}).Error("cannot visit autumnmagic")
+		return nil, err
+	}
+	return offers, nil
+}
+
+// parseStoreNumber extracts the integer part of texts like "1 200 руб." or "4 шт."
+func parseStoreNumber(s string) (int, error) {
+	digits := []rune{}
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+		case len(digits) > 0 && (r == ' ' || r == '\u00a0'):
+			// thousands separator
+		case len(digits) > 0:
+			return strconv.Atoi(string(digits))
+		}
+	}
+	return strconv.Atoi(string(digits))
+}
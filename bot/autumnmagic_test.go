@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+)
+
+// autumnMagicServer serves fixtures by search query, unknown queries get the empty result
+func autumnMagicServer(t *testing.T, fixtures map[string]string) (*httptest.Server, *[]string) {
+	searches := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/catalog" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query().Get("search")
+		searches = append(searches, q)
+		fixture, found := fixtures[q]
+		if !found {
+			fixture = "autumnmagic_empty.html"
+		}
+		b, err := ioutil.ReadFile(path.Join("testdata", fixture))
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	}))
+	return srv, &searches
+}
+
+func checkOffers(t *testing.T, got, want []Offer) {
+	if len(got) != len(want) {
+		t.Fatalf("got %d offers %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("offer %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func amOffer(price float64, qty int, foil bool, url string) Offer {
+	return Offer{
+		Source:   autumnMagicProviderName,
+		Seller:   autumnMagicTrader,
+		Price:    price,
+		Currency: currencyRUB,
+		Foil:     foil,
+		Quantity: qty,
+		URL:      url,
+	}
+}
+
+func TestAutumnMagicOffers(t *testing.T) {
+	srv, searches := autumnMagicServer(t, map[string]string{"lightning bolt": "autumnmagic_search.html"})
+	defer srv.Close()
+
+	card := carddb.Card{Name: "Lightning Bolt", LocalName: "Молния"}
+	offers, err := newAutumnMagic(srv.URL+"/").Offers(context.Background(), card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// out of stock and other cards are skipped, a set description mentioning foil does not make an offer foil
+	checkOffers(t, offers, []Offer{
+		amOffer(120, 4, false, srv.URL+"/catalog/m10/lightning-bolt-146"),
+		amOffer(1200, 1, true, srv.URL+"/catalog/2xm/lightning-bolt-117-foil"),
+		amOffer(350, 2, false, srv.URL+"/catalog/ppro/lightning-bolt-1"),
+	})
+	if len(*searches) != 1 || (*searches)[0] != "lightning bolt" {
+		t.Errorf("searched for %q", *searches)
+	}
+}
+
+func TestAutumnMagicCardNamedFoil(t *testing.T) {
+	srv, _ := autumnMagicServer(t, map[string]string{"foil": "autumnmagic_foil_card.html"})
+	defer srv.Close()
+
+	offers, err := newAutumnMagic(srv.URL).Offers(context.Background(), carddb.Card{Name: "Foil", LocalName: "Foil"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOffers(t, offers, []Offer{
+		amOffer(45, 3, false, srv.URL+"/catalog/pcy/foil-36"),
+		amOffer(300, 1, true, srv.URL+"/catalog/pcy/foil-36-foil"),
+	})
+}
+
+func TestAutumnMagicNoOffers(t *testing.T) {
+	srv, _ := autumnMagicServer(t, nil)
+	defer srv.Close()
+
+	offers, err := newAutumnMagic(srv.URL).Offers(context.Background(), carddb.Card{Name: "Black Lotus", LocalName: "Black Lotus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 0 {
+		t.Errorf("unexpected offers %+v", offers)
+	}
+}
+
+func TestAutumnMagicErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("search") == "slow" {
+			time.Sleep(time.Second)
+		}
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	am := newAutumnMagic(srv.URL)
+
+	if _, err := am.Offers(context.Background(), carddb.Card{Name: "Lightning Bolt"}); err == nil {
+		t.Error("error status is not reported")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := am.Offers(ctx, carddb.Card{Name: "Slow"}); err == nil {
+		t.Error("timeout is not reported")
+	}
+	if took := time.Since(started); took > 500*time.Millisecond {
+		t.Errorf("deadline is not respected, took %s", took)
+	}
+}
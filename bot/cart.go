@@ -0,0 +1,325 @@
+package bot
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultDeliverySeller is the name of delivery rules applied to sellers without their own rules
+const DefaultDeliverySeller = "default"
+
+// DeliveryConfig describes how much a seller charges for delivering an order
+type DeliveryConfig struct {
+	Fee int
+	// FreeFrom is the order subtotal starting from which delivery is free, 0 if it is never free
+	FreeFrom int
+}
+
+// cartCard is a card which should be bought together with all offers for it
+type cartCard struct {
+	name     string
+	quantity int
+	offers   []Offer
+}
+
+type cartItem struct {
+	card  string
+	offer Offer
+}
+
+type sellerCart struct {
+	seller   string
+	items    []cartItem
+	subtotal int
+	delivery int
+}
+
+type cartPlan struct {
+	sellers []*sellerCart
+	missing map[string]int // card -> copies which cannot be bought
+	total   int
+}
+
+func (p *cartPlan) missingCount() int {
+	n := 0
+	for _, m := range p.missing {
+		n += m
+	}
+	return n
+}
+
+// better prefers plans buying more cards and only then cheaper ones
+func (p *cartPlan) better(other cartPlan) bool {
+	if p.missingCount() != other.missingCount() {
+		return p.missingCount() < other.missingCount()
+	}
+	return p.total < other.total
+}
+
+// cartAlternative shows what happens to the plan if a seller is avoided
+type cartAlternative struct {
+	seller  string
+	plan    cartPlan
+	delta   int
+	missing int
+}
+
+type cartPlanner struct {
+	delivery map[string]DeliveryConfig // lowercase seller -> rules
+}
+
+func newCartPlanner(delivery map[string]DeliveryConfig) *cartPlanner {
+	p := &cartPlanner{delivery: make(map[string]DeliveryConfig, len(delivery))}
+	for seller, d := range delivery {
+		p.delivery[strings.ToLower(seller)] = d
+	}
+	return p
+}
+
+func (p *cartPlanner) deliveryFee(seller string, subtotal int) int {
+	d, found := p.delivery[strings.ToLower(seller)]
+	if !found {
+		d = p.delivery[DefaultDeliverySeller]
+	}
+	if d.FreeFrom > 0 && subtotal >= d.FreeFrom {
+		return 0
+	}
+	return d.Fee
+}
+
+// plan looks for the cheapest purchase including delivery. Finding the exact optimum is too expensive,
+// so starting from the cheapest offers sellers are dropped one by one while it makes the total lower,
+// and cards are moved to sellers to reach their free delivery while it does the same
+func (p *cartPlanner) plan(cards []cartCard, banned map[string]bool) cartPlan {
+	excluded := make(map[string]bool, len(banned))
+	for s := range banned {
+		excluded[s] = true
+	}
+	preferred := make(map[string]string)
+
+	best := p.build(cards, excluded, preferred)
+	for {
+		if improved, found := p.dropSeller(cards, excluded, preferred, best); found {
+			best = improved
+			continue
+		}
+		if improved, found := p.consolidate(cards, excluded, preferred, best); found {
+			best = improved
+			continue
+		}
+		return best
+	}
+}
+
+// dropSeller looks for the seller whose exclusion improves the plan most and excludes it
+func (p *cartPlanner) dropSeller(cards []cartCard, excluded map[string]bool, preferred map[string]string, best cartPlan) (cartPlan, bool) {
+	var improved *cartPlan
+	var dropped string
+	for _, sc := range best.sellers {
+		excluded[sc.seller] = true
+		trial := p.build(cards, excluded, preferred)
+		delete(excluded, sc.seller)
+		if trial.better(best) && (improved == nil || trial.better(*improved)) {
+			improved = &trial
+			dropped = sc.seller
+		}
+	}
+	if improved == nil {
+		return best, false
+	}
+	excluded[dropped] = true
+	return *improved, true
+}
+
+// consolidate looks for the seller whose free delivery threshold, once reached by moving cards to it,
+// improves the plan most. Cards moved to the seller are preferred to be bought from it afterwards
+func (p *cartPlanner) consolidate(cards []cartCard, excluded map[string]bool, preferred map[string]string, best cartPlan) (cartPlan, bool) {
+	var improved *cartPlan
+	var moved map[string]string
+	for _, seller := range sellersOf(cards) {
+		if excluded[seller] || p.freeFrom(seller) == 0 {
+			continue
+		}
+		trial, trialPreferred, found := p.reachFreeDelivery(cards, excluded, preferred, best, seller)
+		if found && trial.better(best) && (improved == nil || trial.better(*improved)) {
+			improved = &trial
+			moved = trialPreferred
+		}
+	}
+	if improved == nil {
+		return best, false
+	}
+	for card, seller := range moved {
+		preferred[card] = seller
+	}
+	return *improved, true
+}
+
+// reachFreeDelivery moves cards to the seller starting from the ones costing least extra there
+// until the seller delivers for free. Nothing is returned if the threshold cannot be reached
+func (p *cartPlanner) reachFreeDelivery(cards []cartCard, excluded map[string]bool, preferred map[string]string, plan cartPlan, seller string) (cartPlan, map[string]string, bool) {
+	if sc := plan.seller(seller); sc != nil && sc.delivery == 0 {
+		return plan, nil, false
+	}
+
+	paid := plan.unitPrices()
+	type candidate struct {
+		card  string
+		extra float64
+	}
+	candidates := []candidate{}
+	for _, c := range cards {
+		if preferred[c.name] == seller {
+			continue
+		}
+		for _, o := range c.offers {
+			if o.Seller == seller && o.Quantity > 0 {
+				candidates = append(candidates, candidate{card: c.name, extra: o.Price - paid[c.name]})
+				break
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].extra < candidates[j].extra
+	})
+
+	trialPreferred := make(map[string]string, len(preferred)+len(candidates))
+	for card, s := range preferred {
+		trialPreferred[card] = s
+	}
+	moved := make(map[string]string, len(candidates))
+	for _, cand := range candidates {
+		trialPreferred[cand.card] = seller
+		moved[cand.card] = seller
+		trial := p.build(cards, excluded, trialPreferred)
+		if sc := trial.seller(seller); sc != nil && sc.delivery == 0 {
+			return trial, moved, true
+		}
+	}
+	return plan, nil, false
+}
+
+func (p *cartPlanner) freeFrom(seller string) int {
+	d, found := p.delivery[strings.ToLower(seller)]
+	if !found {
+		d = p.delivery[DefaultDeliverySeller]
+	}
+	return d.FreeFrom
+}
+
+// sellersOf returns every seller having offers of the cards in order of their first appearance
+func sellersOf(cards []cartCard) []string {
+	seen := make(map[string]bool)
+	sellers := []string{}
+	for _, c := range cards {
+		for _, o := range c.offers {
+			if !seen[o.Seller] {
+				seen[o.Seller] = true
+				sellers = append(sellers, o.Seller)
+			}
+		}
+	}
+	return sellers
+}
+
+func (p *cartPlan) seller(name string) *sellerCart {
+	for _, sc := range p.sellers {
+		if sc.seller == name {
+			return sc
+		}
+	}
+	return nil
+}
+
+// unitPrices returns the average price of a copy of every card bought by the plan
+func (p *cartPlan) unitPrices() map[string]float64 {
+	costs := make(map[string]float64)
+	copies := make(map[string]int)
+	for _, sc := range p.sellers {
+		for _, it := range sc.items {
+			costs[it.card] += it.offer.Price * float64(it.offer.Quantity)
+			copies[it.card] += it.offer.Quantity
+		}
+	}
+	for card := range costs {
+		costs[card] /= float64(copies[card])
+	}
+	return costs
+}
+
+// alternatives evaluates the plan avoiding every seller it uses
+func (p *cartPlanner) alternatives(cards []cartCard, plan cartPlan) []cartAlternative {
+	alts := make([]cartAlternative, 0, len(plan.sellers))
+	for _, sc := range plan.sellers {
+		alt := p.plan(cards, map[string]bool{sc.seller: true})
+		alts = append(alts, cartAlternative{
+			seller:  sc.seller,
+			plan:    alt,
+			delta:   alt.total - plan.total,
+			missing: alt.missingCount() - plan.missingCount(),
+		})
+	}
+	return alts
+}
+
+// build takes the cheapest offers of allowed sellers for every card, offers of the preferred seller of a card go first
+func (p *cartPlanner) build(cards []cartCard, excluded map[string]bool, preferred map[string]string) cartPlan {
+	plan := cartPlan{missing: make(map[string]int)}
+	bySeller := make(map[string]*sellerCart)
+	for _, c := range cards {
+		need := c.quantity
+		for _, o := range preferFirst(c.offers, preferred[c.name]) {
+			if need == 0 {
+				break
+			}
+			seller := o.Seller
+			if o.Quantity <= 0 || excluded[seller] {
+				continue
+			}
+			if o.Quantity > need {
+				o.Quantity = need
+			}
+			need -= o.Quantity
+
+			sc, found := bySeller[seller]
+			if !found {
+				sc = &sellerCart{seller: seller}
+				bySeller[seller] = sc
+				plan.sellers = append(plan.sellers, sc)
+			}
+			sc.items = append(sc.items, cartItem{card: c.name, offer: o})
+			sc.subtotal += int(o.Price) * o.Quantity
+		}
+		if need > 0 {
+			plan.missing[c.name] = need
+		}
+	}
+
+	for _, sc := range plan.sellers {
+		sc.delivery = p.deliveryFee(sc.seller, sc.subtotal)
+		plan.total += sc.subtotal + sc.delivery
+	}
+	sort.Slice(plan.sellers, func(i, j int) bool {
+		return plan.sellers[i].subtotal > plan.sellers[j].subtotal
+	})
+	return plan
+}
+
+// preferFirst moves offers of the seller to the front keeping the order otherwise
+func preferFirst(offers []Offer, seller string) []Offer {
+	if seller == "" {
+		return offers
+	}
+	res := make([]Offer, 0, len(offers))
+	for _, o := range offers {
+		if o.Seller == seller {
+			res = append(res, o)
+		}
+	}
+	for _, o := range offers {
+		if o.Seller != seller {
+			res = append(res, o)
+		}
+	}
+	return res
+}
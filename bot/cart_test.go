@@ -0,0 +1,50 @@
+package bot
+
+import "testing"
+
+func offer(seller string, price float64) Offer {
+	return Offer{Seller: seller, Price: price, Currency: currencyRUB, Quantity: 4}
+}
+
+func TestCartPlanReachesFreeDelivery(t *testing.T) {
+	planner := newCartPlanner(map[string]DeliveryConfig{
+		DefaultDeliverySeller: {Fee: 300},
+		"A":                   {Fee: 300, FreeFrom: 1000},
+	})
+	cards := []cartCard{
+		{name: "X", quantity: 1, offers: []Offer{offer("B", 500), offer("A", 520)}},
+		{name: "Y", quantity: 1, offers: []Offer{offer("C", 400), offer("A", 480)}},
+		{name: "Z", quantity: 1, offers: []Offer{offer("B", 100)}},
+	}
+
+	// the cheapest offers cost 1600 with delivery from B and C, dropping either of them does not help,
+	// but buying X and Y from A makes its delivery free
+	plan := planner.plan(cards, nil)
+	if plan.total != 1400 {
+		t.Errorf("total is %d, want 1400", plan.total)
+	}
+	a := plan.seller("A")
+	if a == nil || a.subtotal != 1000 || a.delivery != 0 {
+		t.Fatalf("A is not used up to free delivery: %+v", a)
+	}
+	if plan.seller("C") != nil {
+		t.Error("C is still used")
+	}
+	if plan.missingCount() != 0 {
+		t.Errorf("missing cards %v", plan.missing)
+	}
+}
+
+func TestCartPlanDropsSeller(t *testing.T) {
+	planner := newCartPlanner(map[string]DeliveryConfig{
+		DefaultDeliverySeller: {Fee: 300},
+	})
+	cards := []cartCard{
+		{name: "X", quantity: 2, offers: []Offer{offer("A", 100), offer("B", 120)}},
+		{name: "Y", quantity: 1, offers: []Offer{offer("B", 50)}},
+	}
+	plan := planner.plan(cards, nil)
+	if len(plan.sellers) != 1 || plan.sellers[0].seller != "B" || plan.total != 590 {
+		t.Errorf("plan is %d from %d sellers, want 590 from B only", plan.total, len(plan.sellers))
+	}
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	chartWidth   = 800
+	chartHeight  = 400
+	chartMarginX = 70
+	chartMarginY = 30
+	chartTicks   = 5
+	// chartFontScale enlarges 3x5 glyphs to be readable on phones
+	chartFontScale = 2
+)
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGrid       = color.RGBA{225, 225, 225, 255}
+	chartAxis       = color.RGBA{90, 90, 90, 255}
+
+	chartMinColor = color.RGBA{31, 119, 180, 255}
+	chartAvgColor = color.RGBA{44, 160, 44, 255}
+	chartUSDColor = color.RGBA{214, 39, 40, 255}
+)
+
+type chartPoint struct {
+	at    time.Time
+	value float64
+}
+
+type chartSeries struct {
+	points []chartPoint
+	color  color.RGBA
+	// right series are scaled by the axis on the right side of the chart
+	right bool
+}
+
+// chartAxisRange is a range of values shown along a vertical axis
+type chartAxisRange struct {
+	min, max float64
+	used     bool
+}
+
+func (r *chartAxisRange) add(v float64) {
+	if !r.used {
+		r.min, r.max, r.used = v, v, true
+		return
+	}
+	r.min = math.Min(r.min, v)
+	r.max = math.Max(r.max, v)
+}
+
+func (r *chartAxisRange) pad() {
+	if r.max == r.min {
+		r.min, r.max = r.min*0.9, r.max*1.1+1
+		return
+	}
+	d := (r.max - r.min) * 0.1
+	r.min, r.max = math.Max(0, r.min-d), r.max+d
+}
+
+// renderChart draws series as lines against time and writes PNG image
+func renderChart(w io.Writer, series []chartSeries) error {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.ZP, draw.Src)
+
+	var from, to time.Time
+	var left, right chartAxisRange
+	for _, s := range series {
+		for _, p := range s.points {
+			if from.IsZero() || p.at.Before(from) {
+				from = p.at
+			}
+			if p.at.After(to) {
+				to = p.at
+			}
+			if s.right {
+				right.add(p.value)
+			} else {
+				left.add(p.value)
+			}
+		}
+	}
+	if !to.After(from) {
+		from, to = from.Add(-12*time.Hour), to.Add(12*time.Hour)
+	}
+	left.pad()
+	right.pad()
+
+	x0, x1 := chartMarginX, chartWidth-chartMarginX
+	y0, y1 := chartHeight-chartMarginY, chartMarginY
+	xOf := func(t time.Time) int {
+		return x0 + int(float64(x1-x0)*float64(t.Sub(from))/float64(to.Sub(from)))
+	}
+	yOf := func(r chartAxisRange, v float64) int {
+		return y0 - int(float64(y0-y1)*(v-r.min)/(r.max-r.min))
+	}
+
+	usdPrec := 2
+	if right.max >= 100 {
+		usdPrec = 0
+	}
+	for i := 0; i <= chartTicks; i++ {
+		y := y0 - (y0-y1)*i/chartTicks
+		drawLine(img, x0, y, x1, y, chartGrid, 1)
+		if left.used {
+			v := left.min + (left.max-left.min)*float64(i)/chartTicks
+			label := strconv.Itoa(int(math.Round(v)))
+			drawText(img, x0-8-textWidth(label), y-textHeight()/2, label, chartAxis)
+		}
+		if right.used {
+			v := right.min + (right.max-right.min)*float64(i)/chartTicks
+			drawText(img, x1+8, y-textHeight()/2, "$"+strconv.FormatFloat(v, 'f', usdPrec, 64), chartAxis)
+		}
+
+		t := from.Add(time.Duration(float64(to.Sub(from)) * float64(i) / chartTicks))
+		x := xOf(t)
+		drawLine(img, x, y0, x, y1, chartGrid, 1)
+		label := t.Format("01-02")
+		drawText(img, x-textWidth(label)/2, y0+8, label, chartAxis)
+	}
+	drawLine(img, x0, y0, x1, y0, chartAxis, 1)
+	drawLine(img, x0, y0, x0, y1, chartAxis, 1)
+	if right.used {
+		drawLine(img, x1, y0, x1, y1, chartAxis, 1)
+	}
+
+	for _, s := range series {
+		r := left
+		if s.right {
+			r = right
+		}
+		for i, p := range s.points {
+			x, y := xOf(p.at), yOf(r, p.value)
+			if i == 0 {
+				fillRect(img, x-2, y-2, x+2, y+2, s.color)
+				continue
+			}
+			prev := s.points[i-1]
+			drawLine(img, xOf(prev.at), yOf(r, prev.value), x, y, s.color, 2)
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+func fillRect(img *image.RGBA, xa, ya, xb, yb int, c color.RGBA) {
+	draw.Draw(img, image.Rect(xa, ya, xb+1, yb+1), &image.Uniform{c}, image.ZP, draw.Src)
+}
+
+// drawLine draws a line of the given thickness with Bresenham's algorithm
+func drawLine(img *image.RGBA, xa, ya, xb, yb int, c color.RGBA, thickness int) {
+	dx, dy := absInt(xb-xa), -absInt(yb-ya)
+	sx, sy := 1, 1
+	if xa > xb {
+		sx = -1
+	}
+	if ya > yb {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		fillRect(img, xa, ya, xa+thickness-1, ya+thickness-1, c)
+		if xa == xb && ya == yb {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			xa += sx
+		}
+		if e2 <= dx {
+			e += dx
+			ya += sy
+		}
+	}
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// chartGlyphs is a 3x5 bitmap font covering everything axis labels consist of
+var chartGlyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	'-': {"...", "...", "###", "...", "..."},
+	'$': {".##", "#..", ".#.", "..#", "##."},
+}
+
+func textWidth(s string) int {
+	return len([]rune(s)) * 4 * chartFontScale
+}
+
+func textHeight() int {
+	return 5 * chartFontScale
+}
+
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) {
+	for _, r := range s {
+		g := chartGlyphs[r]
+		for row, line := range g {
+			for col, px := range line {
+				if px == '#' {
+					fx, fy := x+col*chartFontScale, y+row*chartFontScale
+					fillRect(img, fx, fy, fx+chartFontScale-1, fy+chartFontScale-1, c)
+				}
+			}
+		}
+		x += 4 * chartFontScale
+	}
+}
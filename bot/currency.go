@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCurrencyRatesURL is the daily exchange rates of the Central Bank of Russia
+const DefaultCurrencyRatesURL = "https://www.cbr-xml-daily.ru/daily_json.js"
+
+const displayCurrencyProperty = "displayCurrency"
+
+// Currencies converts prices using exchange rates which are reloaded daily
+type Currencies struct {
+	url string
+
+	mu      sync.RWMutex
+	rates   map[string]float64 // currency -> rubles for a single unit
+	updated time.Time
+}
+
+// NewCurrencies creates converter loading rates from url in the format of cbr-xml-daily.ru,
+// nothing is converted until rates are loaded by Update
+func NewCurrencies(url string) *Currencies {
+	if url == "" {
+		url = DefaultCurrencyRatesURL
+	}
+	return &Currencies{
+		url:   url,
+		rates: map[string]float64{currencyRUB: 1},
+	}
+}
+
+type cbrRates struct {
+	Date   string
+	Valute map[string]struct {
+		CharCode string
+		Nominal  float64
+		Value    float64
+	}
+}
+
+// Update loads current exchange rates, previous ones are kept if loading fails
+func (c *Currencies) Update() error {
+	resp, err := http.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rates source has answered %s", resp.Status)
+	}
+
+	var loaded cbrRates
+	if err := json.NewDecoder(resp.Body).Decode(&loaded); err != nil {
+		return err
+	}
+	rates := map[string]float64{currencyRUB: 1}
+	for _, v := range loaded.Valute {
+		if v.Nominal > 0 && v.Value > 0 {
+			rates[strings.ToUpper(v.CharCode)] = v.Value / v.Nominal
+		}
+	}
+	if len(rates) == 1 {
+		return errors.New("rates source has returned no rates")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates = rates
+	c.updated = time.Now()
+	log.WithFields(log.Fields{"date": loaded.Date, "currencies": len(rates)}).Info("currency rates updated")
+	return nil
+}
+
+// Convert returns the amount in another currency, false if rates of any of currencies are unknown
+func (c *Currencies) Convert(amount float64, from, to string) (float64, bool) {
+	if from == to {
+		return amount, true
+	}
+	if c == nil {
+		return 0, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	f, foundFrom := c.rates[from]
+	t, foundTo := c.rates[to]
+	if !foundFrom || !foundTo {
+		return 0, false
+	}
+	return amount * f / t, true
+}
+
+// Known tells whether prices can be converted to the currency
+func (c *Currencies) Known(currency string) bool {
+	_, ok := c.Convert(1, currencyRUB, currency)
+	return ok
+}
+
+// displayCurrency returns currency chosen for the chat, empty if prices are shown as they are
+func displayCurrency(props tgbotbase.PropertyStorage, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	cur, err := props.GetProperty(displayCurrencyProperty, user, chat)
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat, "err": err}).Error("cannot get display currency")
+		return ""
+	}
+	return cur
+}
+
+// moneyFormat shows amounts in the currency chosen for a chat keeping the original amount in parentheses.
+// Zero value shows amounts as they are
+type moneyFormat struct {
+	currencies *Currencies
+	display    string
+}
+
+func (c *Currencies) formatFor(props tgbotbase.PropertyStorage, user tgbotbase.UserID, chat tgbotbase.ChatID) moneyFormat {
+	return moneyFormat{currencies: c, display: displayCurrency(props, user, chat)}
+}
+
+func (m moneyFormat) amount(price float64, currency string) string {
+	orig := formatAmount(price, currency)
+	if m.display == "" || m.display == currency {
+		return orig
+	}
+	converted, ok := m.currencies.Convert(price, currency, m.display)
+	if !ok {
+		return orig
+	}
+	return fmt.Sprintf("%s (%s)", formatAmount(converted, m.display), orig)
+}
+
+// rubles formats amounts which are always counted in rubles like decklist totals
+func (m moneyFormat) rubles(price int) string {
+	return m.amount(float64(price), currencyRUB)
+}
+
+type currencyUpdateJob struct {
+	currencies *Currencies
+	period     time.Duration
+}
+
+// NewCurrencyUpdateJob creates a job which periodically reloads exchange rates
+func NewCurrencyUpdateJob(currencies *Currencies, period time.Duration) tgbotbase.CronJob {
+	return &currencyUpdateJob{
+		currencies: currencies,
+		period:     period,
+	}
+}
+
+func (job *currencyUpdateJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	next := scheduledWhen.Add(job.period)
+	if err := job.currencies.Update(); err != nil {
+		log.WithFields(log.Fields{"url": job.currencies.url, "err": err}).Error("unable to update currency rates")
+		// retry sooner while there are no rates at all
+		job.currencies.mu.RLock()
+		if job.currencies.updated.IsZero() {
+			next = time.Now().Add(15 * time.Minute)
+		}
+		job.currencies.mu.RUnlock()
+	}
+	cron.AddJob(next, job)
+}
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// digestSlot is the delivery slot of the digest, other slots are named after feeds
+const digestSlot = "digest"
+
+// dailyFileIDTTL keeps file ids of daily pictures while chats with later delivery slots may still get them
+const dailyFileIDTTL = 3 * 24 * time.Hour
+
+// dailyPost is an update of a daily feed. Caption is MarkdownV2 made for every chat separately
+// as it may depend on settings of the chat
+type dailyPost struct {
+	feed    string
+	pic     string
+	caption func(chat tgbotbase.ChatID) string
+}
+
+// DailyDelivery sends updates of daily feeds to subscribed chats. Updates published outside of
+// the delivery window of a chat or going to a digest are queued and delivered by cron.
+// Queued updates are kept in memory only, they are lost on restart
+type DailyDelivery struct {
+	tgbotbase.BaseHandler
+
+	cron   tgbotbase.Cron
+	props  tgbotbase.PropertyStorage
+	photos *PhotoSender
+
+	mu        sync.Mutex
+	pending   map[tgbotbase.ChatID]map[string]dailyPost // chat -> feed -> latest update
+	scheduled map[string]bool                           // chat:slot -> delivery job is added
+}
+
+var _ tgbotbase.BackgroundMessageHandler = &DailyDelivery{}
+
+func NewDailyDelivery(cron tgbotbase.Cron, props tgbotbase.PropertyStorage, photos *PhotoSender) *DailyDelivery {
+	return &DailyDelivery{
+		cron:      cron,
+		props:     props,
+		photos:    photos,
+		pending:   make(map[tgbotbase.ChatID]map[string]dailyPost),
+		scheduled: make(map[string]bool),
+	}
+}
+
+func (d *DailyDelivery) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
+	d.OutMsgCh = outMsgCh
+}
+
+func (d *DailyDelivery) Run() {
+}
+
+func (d *DailyDelivery) Name() string {
+	return "daily feeds delivery"
+}
+
+// publish delivers the update to every chat subscribed to its feed now or when the chat wishes
+func (d *DailyDelivery) publish(p dailyPost) {
+	f, found := findFeed(p.feed)
+	if !found {
+		log.WithFields(log.Fields{"feed": p.feed}).Error("update of unknown feed")
+		return
+	}
+	now := time.Now()
+	for _, s := range subscribers(d.props, f.property) {
+		if digest, found := chatDigest(d.props, s.chat); found {
+			d.enqueue(s.chat, p, digestSlot, digest.next(now))
+			continue
+		}
+		when := s.sub.next(now)
+		if !when.After(now) {
+			d.send(s.chat, p)
+			continue
+		}
+		d.enqueue(s.chat, p, p.feed, when)
+	}
+}
+
+// enqueue keeps the update until the slot is delivered, newer updates of the feed replace older ones
+func (d *DailyDelivery) enqueue(chat tgbotbase.ChatID, p dailyPost, slot string, when time.Time) {
+	key := fmt.Sprintf("%d:%s", chat, slot)
+	d.mu.Lock()
+	if d.pending[chat] == nil {
+		d.pending[chat] = make(map[string]dailyPost)
+	}
+	d.pending[chat][p.feed] = p
+	added := d.scheduled[key]
+	d.scheduled[key] = true
+	d.mu.Unlock()
+
+	log.WithFields(log.Fields{"chat": chat, "feed": p.feed, "slot": slot, "when": when}).Debug("daily update is queued")
+	if !added {
+		d.cron.AddJob(when, &dailyDeliveryJob{d: d, chat: chat, slot: slot})
+	}
+}
+
+// take removes queued updates of the slot
+func (d *DailyDelivery) take(chat tgbotbase.ChatID, slot string) []dailyPost {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.scheduled, fmt.Sprintf("%d:%s", chat, slot))
+
+	posts := []dailyPost{}
+	for _, f := range dailyFeeds {
+		p, found := d.pending[chat][f.name]
+		if !found || (slot != digestSlot && slot != f.name) {
+			continue
+		}
+		posts = append(posts, p)
+		delete(d.pending[chat], f.name)
+	}
+	if len(d.pending[chat]) == 0 {
+		delete(d.pending, chat)
+	}
+	return posts
+}
+
+func (d *DailyDelivery) send(chat tgbotbase.ChatID, p dailyPost) {
+	if p.pic == "" {
+		d.sendText(chat, p.caption(chat))
+		return
+	}
+	msg := tgbotapi.NewPhotoUpload(int64(chat), nil)
+	msg.Caption = p.caption(chat)
+	msg.ParseMode = "MarkdownV2"
+	// the picture of an update is the same for every chat, but it is not posted again after the day is over
+	err := d.photos.send(d.OutMsgCh, "daily:"+path.Base(p.pic), dailyFileIDTTL, msg, func() (string, error) {
+		return p.pic, nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat, "pic": p.pic, "err": err}).Error("cannot send daily picture")
+	}
+}
+
+// sendDigest bundles updates into a single message, pictures are left out
+func (d *DailyDelivery) sendDigest(chat tgbotbase.ChatID, posts []dailyPost) {
+	if len(posts) == 1 {
+		d.send(chat, posts[0])
+		return
+	}
+	captions := make([]string, 0, len(posts))
+	for _, p := range posts {
+		captions = append(captions, p.caption(chat))
+	}
+	d.sendText(chat, strings.Join(captions, "\n\n"))
+}
+
+func (d *DailyDelivery) sendText(chat tgbotbase.ChatID, text string) {
+	msg := tgbotapi.NewMessage(int64(chat), text)
+	msg.ParseMode = "MarkdownV2"
+	d.OutMsgCh <- msg
+}
+
+type dailyDeliveryJob struct {
+	d    *DailyDelivery
+	chat tgbotbase.ChatID
+	slot string
+}
+
+func (job *dailyDeliveryJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	posts := job.d.take(job.chat, job.slot)
+	if len(posts) == 0 {
+		return
+	}
+	log.WithFields(log.Fields{"chat": job.chat, "slot": job.slot, "updates": len(posts)}).Info("delivering queued daily updates")
+	if job.slot == digestSlot {
+		job.d.sendDigest(job.chat, posts)
+		return
+	}
+	for _, p := range posts {
+		job.d.send(job.chat, p)
+	}
+}
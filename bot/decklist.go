@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDecklistLines protects from evaluating arbitrary large texts, lines after it are ignored
+const maxDecklistLines = 300
+
+type deckSection int
+
+const (
+	sectionMain deckSection = iota
+	sectionSideboard
+)
+
+type deckEntry struct {
+	name     string
+	quantity int
+	section  deckSection
+	line     string
+}
+
+var (
+	deckLineRe     = regexp.MustCompile(`^(?:(\d+)x?\s+)?(.+?)$`)
+	deckPrintingRe = regexp.MustCompile(`\s+\([0-9A-Za-z]+\)(?:\s+[0-9A-Za-z★-]+)?(?:\s+\*F\*)?$`)
+)
+
+// parseDecklist understands MTGO and Arena exports: "4 Lightning Bolt", "4x Lightning Bolt (M10) 146",
+// "SB: 2 Duress" and sideboard sections started either by a header or by an empty line.
+// Lines which do not look like a card are returned separately, truncated tells that lines after maxDecklistLines were ignored
+func parseDecklist(r io.Reader) (entries []deckEntry, bad []string, truncated bool, err error) {
+	entries = []deckEntry{}
+	bad = []string{}
+	section := sectionMain
+	scanner := bufio.NewScanner(r)
+	lines := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			// MTGO separates sideboard with an empty line
+			if len(entries) > 0 {
+				section = sectionSideboard
+			}
+			continue
+		}
+		lines++
+		if lines > maxDecklistLines {
+			truncated = true
+			break
+		}
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch strings.ToLower(strings.TrimRight(line, ":")) {
+		case "deck", "main", "maindeck", "commander", "companion":
+			section = sectionMain
+			continue
+		case "sideboard", "sb", "maybeboard":
+			section = sectionSideboard
+			continue
+		}
+
+		entrySection := section
+		if strings.HasPrefix(strings.ToUpper(line), "SB:") {
+			entrySection = sectionSideboard
+			line = strings.TrimSpace(line[3:])
+		}
+		m := deckLineRe.FindStringSubmatch(line)
+		if m == nil {
+			bad = append(bad, line)
+			continue
+		}
+		quantity := 1
+		if m[1] != "" {
+			q, err := strconv.Atoi(m[1])
+			if err != nil || q <= 0 {
+				bad = append(bad, line)
+				continue
+			}
+			quantity = q
+		}
+		name := strings.TrimSpace(deckPrintingRe.ReplaceAllString(m[2], ""))
+		if name == "" {
+			bad = append(bad, line)
+			continue
+		}
+		entries = append(entries, deckEntry{name: name, quantity: quantity, section: entrySection, line: line})
+	}
+	return entries, bad, truncated, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDecklist(t *testing.T) {
+	text := `Deck
+4 Lightning Bolt
+4x Counterspell (M10) 50
+// comment
+???
+
+SB: 2 Duress
+Sideboard
+1 Negate`
+	entries, bad, truncated, err := parseDecklist(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated {
+		t.Error("short decklist is truncated")
+	}
+	want := []deckEntry{
+		{name: "Lightning Bolt", quantity: 4, section: sectionMain},
+		{name: "Counterspell", quantity: 4, section: sectionMain},
+		{name: "???", quantity: 1, section: sectionMain},
+		{name: "Duress", quantity: 2, section: sectionSideboard},
+		{name: "Negate", quantity: 1, section: sectionSideboard},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries %+v, want %d", len(entries), entries, len(want))
+	}
+	for i, e := range entries {
+		if e.name != want[i].name || e.quantity != want[i].quantity || e.section != want[i].section {
+			t.Errorf("entry %d is %+v, want %+v", i, e, want[i])
+		}
+	}
+	if len(bad) != 0 {
+		t.Errorf("unexpected bad lines %q", bad)
+	}
+}
+
+func TestParseDecklistTruncated(t *testing.T) {
+	lines := make([]string, 0, maxDecklistLines+10)
+	for i := 0; i < maxDecklistLines+10; i++ {
+		lines = append(lines, fmt.Sprintf("1 Card %d", i))
+	}
+	entries, _, truncated, err := parseDecklist(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated {
+		t.Error("long decklist is not reported as truncated")
+	}
+	if len(entries) != maxDecklistLines {
+		t.Errorf("got %d entries, want %d", len(entries), maxDecklistLines)
+	}
+}
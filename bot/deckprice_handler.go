@@ -0,0 +1,380 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// maxDecklistFileSize limits attached decklists, real ones are only a few kilobytes
+const maxDecklistFileSize = 64 * 1024
+
+type deckPriceHandler struct {
+	tgbotbase.BaseHandler
+
+	api        *tgbotapi.BotAPI
+	cards      *carddb.CardDB
+	props      tgbotbase.PropertyStorage
+	prices     *PriceCache
+	currencies *Currencies
+	planner    *cartPlanner
+}
+
+var _ tgbotbase.IncomingMessageHandler = &deckPriceHandler{}
+
+// NewDeckPriceHandler evaluates decklists, api is used to download attached files and may be nil.
+// Delivery rules are keyed by seller name, DefaultDeliverySeller applies to the rest of sellers
+func NewDeckPriceHandler(api *tgbotapi.BotAPI,
+	cards *carddb.CardDB,
+	props tgbotbase.PropertyStorage,
+	prices *PriceCache,
+	currencies *Currencies,
+	delivery map[string]DeliveryConfig) tgbotbase.IncomingMessageHandler {
+	return &deckPriceHandler{
+		api:        api,
+		cards:      cards,
+		props:      props,
+		prices:     prices,
+		currencies: currencies,
+		planner:    newCartPlanner(delivery),
+	}
+}
+
+func (h *deckPriceHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *deckPriceHandler) trigger() (*regexp.Regexp, []string) {
+	return nil, []string{"deckprice", "cart"}
+}
+
+// deckCard is a card of the decklist resolved against the card index
+type deckCard struct {
+	card      carddb.Card
+	quantity  int
+	sideboard int
+}
+
+// deckIssues are parts of the decklist which are left out of the evaluation
+type deckIssues struct {
+	unknown []string // lines which are not recognized as cards
+	// unpriced cards have no answer of price providers, unlike the cards which are not sold anywhere
+	unpriced  []string
+	truncated bool
+}
+
+func (i deckIssues) lines() []string {
+	lines := []string{}
+	if len(i.unpriced) > 0 {
+		lines = append(lines, "", "Could not get prices for:")
+		for _, name := range i.unpriced {
+			lines = append(lines, escapeMarkdown(name))
+		}
+	}
+	if len(i.unknown) > 0 {
+		lines = append(lines, "", "Unknown lines:")
+		for _, u := range i.unknown {
+			lines = append(lines, escapeMarkdown(u))
+		}
+	}
+	if i.truncated {
+		lines = append(lines, "", escapeMarkdown(fmt.Sprintf("The decklist is truncated: only the first %d lines are evaluated", maxDecklistLines)))
+	}
+	return lines
+}
+
+// deckCardPrice is the cheapest way to buy all copies of a single card
+type deckCardPrice struct {
+	deckCard
+	offers  []Offer
+	total   int
+	missing int
+}
+
+func (h *deckPriceHandler) HandleOne(msg tgbotapi.Message) {
+	text, err := h.decklistText(msg)
+	if err != nil {
+		h.reply(msg, err.Error())
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		h.reply(msg, fmt.Sprintf("Paste a decklist after /%s (one card per line like \"4 Lightning Bolt\") or reply with /%s to a message with an attached .txt decklist", msg.Command(), msg.Command()))
+		return
+	}
+
+	entries, unknown, truncated, err := parseDecklist(strings.NewReader(text))
+	if err != nil {
+		log.WithFields(log.Fields{"chat": msg.Chat.ID, "err": err}).Error("cannot read decklist")
+		h.reply(msg, "Could not read the decklist")
+		return
+	}
+
+	cards := []*deckCard{}
+	byName := make(map[string]*deckCard)
+	for _, e := range entries {
+		c, _, found := h.cards.FindName(carddb.NormalizeName(e.name), 1)
+		if !found {
+			unknown = append(unknown, e.line)
+			continue
+		}
+		dc, found := byName[c.Name]
+		if !found {
+			dc = &deckCard{card: c}
+			byName[c.Name] = dc
+			cards = append(cards, dc)
+		}
+		dc.quantity += e.quantity
+		if e.section == sectionSideboard {
+			dc.sideboard += e.quantity
+		}
+	}
+	if len(cards) == 0 {
+		h.reply(msg, "I could not recognize any card in the decklist")
+		return
+	}
+
+	text = fmt.Sprintf("Looking for %d different cards in stores, it may take a while", len(cards))
+	if truncated {
+		text = fmt.Sprintf("%s. The decklist is too long, only the first %d lines are evaluated", text, maxDecklistLines)
+	}
+	h.reply(msg, text)
+	// mtgbulk looks for cards of the batch one by one, so the evaluation is not blocking other requests
+	go h.evaluate(cards, deckIssues{unknown: unknown, truncated: truncated}, msg, msg.Command() == "cart")
+}
+
+// fetch gets rouble offers of every card in a single batch, cards which no provider has answered for are left out
+func (h *deckPriceHandler) fetch(all []*deckCard) map[string][]Offer {
+	reqs := make([]CardRequest, 0, len(all))
+	for _, dc := range all {
+		reqs = append(reqs, CardRequest{Card: dc.card, Quantity: dc.quantity})
+	}
+	offers := make(map[string][]Offer, len(all))
+	for id, p := range h.prices.BatchPrices(reqs) {
+		offers[id] = p.InCurrency(currencyRUB)
+	}
+	return offers
+}
+
+func (h *deckPriceHandler) evaluate(all []*deckCard, issues deckIssues, msg tgbotapi.Message, cart bool) {
+	offers := h.fetch(all)
+	cards := make([]*deckCard, 0, len(all))
+	for _, dc := range all {
+		if _, found := offers[dc.card.ID]; !found {
+			issues.unpriced = append(issues.unpriced, dc.card.Name)
+			continue
+		}
+		cards = append(cards, dc)
+	}
+	if len(cards) == 0 {
+		log.WithFields(log.Fields{"chat": msg.Chat.ID, "cards": len(all)}).Error("cannot get deck prices")
+		h.reply(msg, "Could not get prices for the decklist")
+		return
+	}
+	sort.Strings(issues.unpriced)
+
+	var user tgbotbase.UserID
+	if msg.From != nil {
+		user = tgbotbase.UserID(msg.From.ID)
+	}
+	money := h.currencies.formatFor(h.props, user, tgbotbase.ChatID(msg.Chat.ID))
+	var lines []string
+	if cart {
+		cartCards := make([]cartCard, 0, len(cards))
+		for _, dc := range cards {
+			cartCards = append(cartCards, cartCard{name: dc.card.Name, quantity: dc.quantity, offers: offers[dc.card.ID]})
+		}
+		plan := h.planner.plan(cartCards, nil)
+		lines = formatCart(plan, h.planner.alternatives(cartCards, plan), issues, money)
+	} else {
+		prices := make([]deckCardPrice, 0, len(cards))
+		for _, dc := range cards {
+			prices = append(prices, cheapestOffers(*dc, offers[dc.card.ID]))
+		}
+		lines = formatDeckPrices(prices, issues, money)
+	}
+	for _, text := range splitMessage(lines) {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+		reply.ParseMode = "MarkdownV2"
+		reply.DisableWebPagePreview = true
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+	}
+}
+
+// cheapestOffers picks offers sorted by price until every copy of the card is bought
+func cheapestOffers(dc deckCard, offers []Offer) deckCardPrice {
+	p := deckCardPrice{deckCard: dc, missing: dc.quantity}
+	for _, o := range offers {
+		if p.missing == 0 {
+			break
+		}
+		if o.Quantity <= 0 {
+			continue
+		}
+		if o.Quantity > p.missing {
+			o.Quantity = p.missing
+		}
+		p.offers = append(p.offers, o)
+		p.total += int(o.Price) * o.Quantity
+		p.missing -= o.Quantity
+	}
+	return p
+}
+
+func formatDeckPrices(prices []deckCardPrice, issues deckIssues, money moneyFormat) []string {
+	sort.Slice(prices, func(i, j int) bool {
+		if prices[i].total != prices[j].total {
+			return prices[i].total > prices[j].total
+		}
+		return prices[i].card.Name < prices[j].card.Name
+	})
+
+	total, copies, found := 0, 0, 0
+	lines := []string{}
+	missing := []string{}
+	for _, p := range prices {
+		copies += p.quantity
+		found += p.quantity - p.missing
+		total += p.total
+		if p.missing > 0 {
+			missing = append(missing, escapeMarkdown(fmt.Sprintf("%d %s", p.missing, p.card.Name)))
+		}
+		if len(p.offers) == 0 {
+			continue
+		}
+		sellers := make([]string, 0, len(p.offers))
+		for _, o := range p.offers {
+			seller := fmt.Sprintf("%s %s", o.Seller, money.amount(o.Price, o.Currency))
+			if o.Quantity > 1 {
+				seller = fmt.Sprintf("%s x%d", seller, o.Quantity)
+			}
+			sellers = append(sellers, fmt.Sprintf("[%s](%s)", escapeMarkdown(seller), o.URL))
+		}
+		line := escapeMarkdown(fmt.Sprintf("%d %s: %s", p.quantity-p.missing, p.card.Name, money.rubles(p.total)))
+		if p.sideboard > 0 {
+			line += escapeMarkdown(fmt.Sprintf(" (%d in sideboard)", p.sideboard))
+		}
+		lines = append(lines, fmt.Sprintf("%s \\- %s", line, strings.Join(sellers, ", ")))
+	}
+
+	header := []string{escapeMarkdown(fmt.Sprintf("Cheapest buy: %s for %d of %d cards", money.rubles(total), found, copies)), ""}
+	lines = append(header, lines...)
+	if len(missing) > 0 {
+		lines = append(lines, "", "Not available in stores:")
+		lines = append(lines, missing...)
+	}
+	return append(lines, issues.lines()...)
+}
+
+func formatCart(plan cartPlan, alts []cartAlternative, issues deckIssues, money moneyFormat) []string {
+	delivery := 0
+	for _, sc := range plan.sellers {
+		delivery += sc.delivery
+	}
+	lines := []string{escapeMarkdown(fmt.Sprintf("Purchase plan: %s including %s for delivery from %d sellers", money.rubles(plan.total), money.rubles(delivery), len(plan.sellers)))}
+	for _, sc := range plan.sellers {
+		lines = append(lines, "", fmt.Sprintf("*%s*: %s", escapeMarkdown(sc.seller), escapeMarkdown(fmt.Sprintf("%s + %s delivery", money.rubles(sc.subtotal), money.rubles(sc.delivery)))))
+		for _, it := range sc.items {
+			item := fmt.Sprintf("%d %s", it.offer.Quantity, it.card)
+			price := money.amount(it.offer.Price, it.offer.Currency)
+			if it.offer.Quantity > 1 {
+				price = fmt.Sprintf("%d×%s", it.offer.Quantity, price)
+			}
+			line := fmt.Sprintf("[%s](%s) %s", escapeMarkdown(item), it.offer.URL, escapeMarkdown(price))
+			if it.offer.Foil {
+				line += " foil"
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if len(plan.missing) > 0 {
+		lines = append(lines, "", "Not available in stores:")
+		names := make([]string, 0, len(plan.missing))
+		for name := range plan.missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, escapeMarkdown(fmt.Sprintf("%d %s", plan.missing[name], name)))
+		}
+	}
+
+	if len(alts) > 0 {
+		lines = append(lines, "", "Avoiding a seller:")
+		for _, a := range alts {
+			change := "+" + money.rubles(a.delta)
+			if a.delta < 0 {
+				change = "-" + money.rubles(-a.delta)
+			}
+			if a.missing > 0 {
+				change = fmt.Sprintf("%s, %d cards cannot be bought", change, a.missing)
+			}
+			lines = append(lines, escapeMarkdown(fmt.Sprintf("without %s: %s (%d sellers)", a.seller, change, len(a.plan.sellers))))
+		}
+	}
+
+	return append(lines, issues.lines()...)
+}
+
+// decklistText returns the decklist either from command arguments or from the message the command replies to.
+// Captions of documents are not seen by handlers, so a file has to be replied to
+func (h *deckPriceHandler) decklistText(msg tgbotapi.Message) (string, error) {
+	if args := msg.CommandArguments(); strings.TrimSpace(args) != "" {
+		return args, nil
+	}
+	if msg.ReplyToMessage == nil {
+		return "", nil
+	}
+	doc := msg.ReplyToMessage.Document
+	if doc == nil {
+		return msg.ReplyToMessage.Text, nil
+	}
+	if h.api == nil {
+		return "", fmt.Errorf("Attached files are not supported, paste the decklist as text")
+	}
+	if doc.FileSize > maxDecklistFileSize {
+		return "", fmt.Errorf("The file is too large for a decklist")
+	}
+	if !strings.HasSuffix(strings.ToLower(doc.FileName), ".txt") && !strings.HasPrefix(doc.MimeType, "text/") {
+		return "", fmt.Errorf("Only .txt decklists are supported")
+	}
+
+	url, err := h.api.GetFileDirectURL(doc.FileID)
+	if err != nil {
+		log.WithFields(log.Fields{"fileID": doc.FileID, "err": err}).Error("cannot get decklist file URL")
+		return "", fmt.Errorf("Could not download the decklist")
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		log.WithFields(log.Fields{"fileID": doc.FileID, "err": err}).Error("cannot download decklist file")
+		return "", fmt.Errorf("Could not download the decklist")
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDecklistFileSize))
+	if err != nil {
+		log.WithFields(log.Fields{"fileID": doc.FileID, "err": err}).Error("cannot read decklist file")
+		return "", fmt.Errorf("Could not download the decklist")
+	}
+	return string(b), nil
+}
+
+func (h *deckPriceHandler) reply(msg tgbotapi.Message, text string) {
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func (h *deckPriceHandler) Name() string {
+	return "Deck Price Handler"
+}
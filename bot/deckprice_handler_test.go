@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/mtgbulkbuy/pkg/mtgbulk"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// noProps has no properties set for anyone
+type noProps struct {
+	tgbotbase.PropertyStorage
+}
+
+func (noProps) GetProperty(name string, user tgbotbase.UserID, chat tgbotbase.ChatID) (string, error) {
+	return "", nil
+}
+
+// testCardDB loads the cards as if they were a downloaded dump
+func testCardDB(t *testing.T, cards ...carddb.Card) (*carddb.CardDB, func()) {
+	dir, err := ioutil.TempDir("", "carddb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "all.dump.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := carddb.New(carddb.Config{Dir: dir})
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return db, func() { os.RemoveAll(dir) }
+}
+
+// countingMtgbulk answers every request with an offer of each card and remembers the requests
+type countingMtgbulk struct {
+	mu       sync.Mutex
+	requests []map[string]int
+}
+
+func (m *countingMtgbulk) process(req mtgbulk.NamesRequest) (*mtgbulk.NamesResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	asked := make(map[string]int, len(req.Cards))
+	res := &mtgbulk.NamesResult{AllSortedCards: make(map[string]mtgbulk.CardResult, len(req.Cards))}
+	for name, qty := range req.Cards {
+		asked[name] = qty
+		res.AllSortedCards[name] = mtgbulk.CardResult{
+			Available: true,
+			Prices:    []mtgbulk.CardPrice{{Price: 100, Quantity: qty, Trader: "store"}},
+		}
+	}
+	m.requests = append(m.requests, asked)
+	return res, nil
+}
+
+func (m *countingMtgbulk) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.requests)
+}
+
+func TestDeckPriceMakesOneMtgbulkRequest(t *testing.T) {
+	db, cleanup := testCardDB(t,
+		carddb.Card{ID: "bolt", OracleID: "bolt", Name: "Lightning Bolt", Lang: "en"},
+		carddb.Card{ID: "counterspell", OracleID: "counterspell", Name: "Counterspell", Lang: "en"},
+		carddb.Card{ID: "borrower", OracleID: "borrower", Name: "Brazen Borrower", Lang: "en"},
+	)
+	defer cleanup()
+
+	upstream := &countingMtgbulk{}
+	bulk := newMtgbulkProvider()
+	bulk.process = upstream.process
+	h := NewDeckPriceHandler(nil, db, noProps{}, testPriceCache(bulk), NewCurrencies(""), nil)
+	out := make(chan tgbotapi.Chattable, 10)
+	h.Init(out, nil)
+
+	deck := "/deckprice 4 Lightning Bolt\n2 Counterspell\n1 Brazen Borrower\nSideboard\n2 Lightning Bolt"
+	for i := 0; i < 2; i++ {
+		h.HandleOne(*command(deck, "deckprice"))
+		for _, want := range []string{"Looking for 3 different cards", "Lightning Bolt"} {
+			select {
+			case reply := <-out:
+				if text := reply.(tgbotapi.MessageConfig).Text; !strings.Contains(text, want) {
+					t.Errorf("reply %q does not mention %q", text, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no reply mentioning %q", want)
+			}
+		}
+	}
+
+	// the second evaluation is served from cache
+	if n := upstream.count(); n != 1 {
+		t.Fatalf("mtgbulk is requested %d times, want a single batch", n)
+	}
+	want := map[string]int{"Lightning Bolt": 6, "Counterspell": 2, "Brazen Borrower": 1}
+	got := upstream.requests[0]
+	if len(got) != len(want) {
+		t.Errorf("mtgbulk is asked for %v, want %v", got, want)
+	}
+	for name, qty := range want {
+		if got[name] != qty {
+			t.Errorf("mtgbulk is asked for %d of %s, want %d", got[name], name, qty)
+		}
+	}
+}
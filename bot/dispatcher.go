@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// messageTrigger is implemented by message handlers of the package. It returns what is given
+// to tgbotbase.NewHandlerTrigger, as the trigger itself keeps them private
+type messageTrigger interface {
+	trigger() (*regexp.Regexp, []string)
+}
+
+type messageRoute struct {
+	h    tgbotbase.IncomingMessageHandler
+	re   *regexp.Regexp
+	cmds map[string]bool
+	ch   chan tgbotapi.Message
+}
+
+// matches decides the same way tgbotbase.HandlerTrigger does
+func (r *messageRoute) matches(msg tgbotapi.Message) bool {
+	if r.re != nil && r.re.MatchString(strings.ToLower(msg.Text)) {
+		return true
+	}
+	return msg.IsCommand() && r.cmds[msg.Command()]
+}
+
+type inlineRoute struct {
+	h  InlineQueryHandler
+	ch chan tgbotapi.InlineQuery
+}
+
+// Dispatcher polls Telegram updates and passes them to handlers in place of tgbotbase.Bot,
+// which skips every update except messages. Messages are dispatched the way tgbotbase does it,
+// inline queries go to inline handlers
+type Dispatcher struct {
+	api      *tgbotapi.BotAPI
+	outMsgCh chan tgbotapi.Chattable
+	srvCh    chan tgbotbase.ServiceMsg
+
+	messages   []*messageRoute
+	inline     []*inlineRoute
+	background []tgbotbase.BackgroundMessageHandler
+}
+
+// NewDispatcher creates a dispatcher using the api for both updates and replies,
+// without api nothing is received and replies are dropped
+func NewDispatcher(api *tgbotapi.BotAPI) *Dispatcher {
+	return &Dispatcher{
+		api:      api,
+		outMsgCh: make(chan tgbotapi.Chattable),
+		srvCh:    make(chan tgbotbase.ServiceMsg),
+	}
+}
+
+// AddMessageHandler registers a handler of the package, its Init is called right away
+func (d *Dispatcher) AddMessageHandler(h tgbotbase.IncomingMessageHandler) {
+	log.WithFields(log.Fields{"handler": h.Name()}).Info("preparing message handler")
+	h.Init(d.outMsgCh, d.srvCh)
+	t, ok := h.(messageTrigger)
+	if !ok {
+		log.WithFields(log.Fields{"handler": h.Name()}).Panic("message handler does not tell its trigger")
+	}
+	re, cmds := t.trigger()
+	r := &messageRoute{h: h, re: re, cmds: make(map[string]bool, len(cmds)), ch: make(chan tgbotapi.Message)}
+	for _, c := range cmds {
+		r.cmds[c] = true
+	}
+	d.messages = append(d.messages, r)
+}
+
+func (d *Dispatcher) AddInlineHandler(h InlineQueryHandler) {
+	log.WithFields(log.Fields{"handler": h.Name()}).Info("preparing inline handler")
+	d.inline = append(d.inline, &inlineRoute{h: h, ch: make(chan tgbotapi.InlineQuery)})
+}
+
+func (d *Dispatcher) AddBackgroundHandler(h tgbotbase.BackgroundMessageHandler) {
+	log.WithFields(log.Fields{"handler": h.Name()}).Info("preparing background handler")
+	h.Init(d.outMsgCh, d.srvCh)
+	d.background = append(d.background, h)
+}
+
+// Start runs handlers and dispatches updates until they stop coming
+func (d *Dispatcher) Start() {
+	for _, r := range d.messages {
+		go func(r *messageRoute) {
+			for msg := range r.ch {
+				r.h.HandleOne(msg)
+			}
+		}(r)
+	}
+	for _, r := range d.inline {
+		go func(r *inlineRoute) {
+			for q := range r.ch {
+				r.h.HandleInline(q)
+			}
+		}(r)
+	}
+	for _, h := range d.background {
+		h.Run()
+	}
+	go d.serveReplies()
+
+	var updates tgbotapi.UpdatesChannel
+	if d.api != nil {
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		var err error
+		if updates, err = d.api.GetUpdatesChan(u); err != nil {
+			log.WithFields(log.Fields{"err": err}).Panic("cannot get updates")
+		}
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.dispatch(update)
+		case srvMsg := <-d.srvCh:
+			log.WithFields(log.Fields{"msg": srvMsg}).Info("service message is received")
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(update tgbotapi.Update) {
+	switch {
+	case update.Message != nil:
+		for _, r := range d.messages {
+			if r.matches(*update.Message) {
+				r.ch <- *update.Message
+			}
+		}
+	case update.InlineQuery != nil:
+		for _, r := range d.inline {
+			r.ch <- *update.InlineQuery
+		}
+	default:
+		log.WithFields(log.Fields{"update": update.UpdateID}).Debug("update is skipped")
+	}
+}
+
+func (d *Dispatcher) serveReplies() {
+	for msg := range d.outMsgCh {
+		if d.api == nil {
+			log.WithFields(log.Fields{"msg": msg}).Warn("not connected, reply is dropped")
+			continue
+		}
+		if _, err := d.api.Send(msg); err != nil {
+			log.WithFields(log.Fields{"msg": msg, "err": err}).Error("cannot send reply")
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/admirallarimda/tgbotbase"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type testMessageHandler struct {
+	tgbotbase.BaseHandler
+	got chan string
+}
+
+func (h *testMessageHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *testMessageHandler) trigger() (*regexp.Regexp, []string) {
+	return re, []string{"find"}
+}
+
+func (h *testMessageHandler) HandleOne(msg tgbotapi.Message) { h.got <- msg.Text }
+func (h *testMessageHandler) Name() string                   { return "test messages" }
+
+type testInlineHandler struct {
+	got chan string
+}
+
+func (h *testInlineHandler) HandleInline(q tgbotapi.InlineQuery) { h.got <- q.Query }
+func (h *testInlineHandler) Name() string                        { return "test inline" }
+
+func command(text, cmd string) *tgbotapi.Message {
+	msg := &tgbotapi.Message{Text: text, Chat: &tgbotapi.Chat{ID: 1}}
+	if cmd != "" {
+		msg.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd) + 1}}
+	}
+	return msg
+}
+
+func TestDispatcherRoutesUpdates(t *testing.T) {
+	msgs := &testMessageHandler{got: make(chan string, 10)}
+	inline := &testInlineHandler{got: make(chan string, 10)}
+	d := NewDispatcher(nil)
+	d.AddMessageHandler(msgs)
+	d.AddInlineHandler(inline)
+	go d.Start()
+
+	d.dispatch(tgbotapi.Update{Message: command("hello there", "")})
+	d.dispatch(tgbotapi.Update{Message: command("/watch bolt", "watch")})
+	d.dispatch(tgbotapi.Update{Message: command("show [[Bolt]]", "")})
+	d.dispatch(tgbotapi.Update{InlineQuery: &tgbotapi.InlineQuery{ID: "1", Query: "bolt"}})
+	d.dispatch(tgbotapi.Update{Message: command("/find bolt", "find")})
+	d.dispatch(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{ID: "2"}})
+
+	if got := <-inline.got; got != "bolt" {
+		t.Errorf("inline handler got %q", got)
+	}
+	for _, want := range []string{"show [[Bolt]]", "/find bolt"} {
+		if got := <-msgs.got; got != want {
+			t.Errorf("message handler got %q, want %q", got, want)
+		}
+	}
+	select {
+	case got := <-msgs.got:
+		t.Errorf("message %q is not expected", got)
+	default:
+	}
+}
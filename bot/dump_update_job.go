@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+)
+
+type dumpUpdateJob struct {
+	cards  *carddb.CardDB
+	period time.Duration
+}
+
+// NewDumpUpdateJob creates a job which periodically checks Scryfall for a new dump and reloads cards
+func NewDumpUpdateJob(cards *carddb.CardDB, period time.Duration) tgbotbase.CronJob {
+	return &dumpUpdateJob{
+		cards:  cards,
+		period: period,
+	}
+}
+
+func (job *dumpUpdateJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	defer cron.AddJob(scheduledWhen.Add(job.period), job)
+
+	updated, err := job.cards.Update()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Unable to update cards dump")
+		return
+	}
+	log.WithFields(log.Fields{"updated": updated}).Info("cards dump update check done")
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/admirallarimda/tgbotbase"
-	"github.com/ilyalavrinov/mtgbulkbuy/pkg/mtgbulk"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
 	log "github.com/sirupsen/logrus"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
@@ -18,13 +18,17 @@ type edhrecCmdrDailyUpdate struct {
 	url, picUrl string
 	rankInfo    string
 	salt        float32
-	minPrice    price
+	minPrice    Offer
 }
 
 type edhrecCmdrDailyHandler struct {
 	tgbotbase.BaseHandler
-	props tgbotbase.PropertyStorage
-	cron  tgbotbase.Cron
+	props      tgbotbase.PropertyStorage
+	cron       tgbotbase.Cron
+	cards      *carddb.CardDB
+	prices     *PriceCache
+	currencies *Currencies
+	delivery   *DailyDelivery
 
 	updates chan edhrecCmdrDailyUpdate
 }
@@ -32,10 +36,18 @@ type edhrecCmdrDailyHandler struct {
 var _ tgbotbase.BackgroundMessageHandler = &edhrecCmdrDailyHandler{}
 
 func NewEdhrecCmdrDailyHandler(cron tgbotbase.Cron,
-	props tgbotbase.PropertyStorage) tgbotbase.BackgroundMessageHandler {
+	props tgbotbase.PropertyStorage,
+	cards *carddb.CardDB,
+	prices *PriceCache,
+	currencies *Currencies,
+	delivery *DailyDelivery) tgbotbase.BackgroundMessageHandler {
 	h := &edhrecCmdrDailyHandler{
-		props: props,
-		cron:  cron,
+		props:      props,
+		cron:       cron,
+		cards:      cards,
+		prices:     prices,
+		currencies: currencies,
+		delivery:   delivery,
 	}
 	h.updates = make(chan edhrecCmdrDailyUpdate, 0)
 	return h
@@ -46,15 +58,6 @@ func (h *edhrecCmdrDailyHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh
 }
 
 func (h *edhrecCmdrDailyHandler) Run() {
-	chatsToNotify := make([]tgbotbase.ChatID, 0)
-	props, _ := h.props.GetEveryHavingProperty("edhrecCmdrDailyNotify")
-	for _, prop := range props {
-		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
-			continue
-		}
-		chatsToNotify = append(chatsToNotify, prop.Chat)
-	}
-
 	prevDealName, err := h.props.GetProperty("edhrecCmdrDailyLast", 0, 0)
 	if err != nil {
 		panic(fmt.Sprintf("Could not get last mtgsale deal, err: %s", err))
@@ -81,21 +84,28 @@ func (h *edhrecCmdrDailyHandler) Run() {
 				saltScore := fmt.Sprintf("Salt score: %.2f", data.salt)
 				saltScore = escapeMarkdown(saltScore)
 				data.rankInfo = escapeMarkdown(data.rankInfo)
-				text := fmt.Sprintf("Commander of the day\n[%s](%s)\n%s\n%s", data.cardname, data.url, data.rankInfo, saltScore)
-				if data.minPrice.Price != 0 {
-					text = fmt.Sprintf("%s\n%s", text, formatPrice("min", data.minPrice))
-				}
-				for _, chatID := range chatsToNotify {
-					msg := tgbotapi.NewPhotoUpload(int64(chatID), picFName)
-					msg.Caption = text
-					msg.ParseMode = "MarkdownV2"
-					h.OutMsgCh <- msg
+				text := fmt.Sprintf("Commander of the day\n[%s](%s)", data.cardname, data.url)
+				if c, _, found := h.cards.FindName(data.cardname, maxCandidates); found {
+					text = fmt.Sprintf("%s\n%s", text, formatCardInfo(c))
 				}
+				text = fmt.Sprintf("%s\n%s\n%s", text, data.rankInfo, saltScore)
+				minPrice := data.minPrice
+				h.delivery.publish(dailyPost{
+					feed: edhrecFeed,
+					pic:  picFName,
+					caption: func(chat tgbotbase.ChatID) string {
+						if minPrice.Price == 0 {
+							return text
+						}
+						money := h.currencies.formatFor(h.props, 0, chat)
+						return fmt.Sprintf("%s\n%s", text, formatPrice("min", minPrice, money))
+					},
+				})
 			}
 		}
 	}()
 
-	h.cron.AddJob(time.Now(), &edhrecCmdrDailyJob{updates: h.updates})
+	h.cron.AddJob(time.Now(), &edhrecCmdrDailyJob{updates: h.updates, cards: h.cards, prices: h.prices})
 }
 
 func (h *edhrecCmdrDailyHandler) Name() string {
@@ -104,6 +114,8 @@ func (h *edhrecCmdrDailyHandler) Name() string {
 
 type edhrecCmdrDailyJob struct {
 	updates chan<- edhrecCmdrDailyUpdate
+	cards   *carddb.CardDB
+	prices  *PriceCache
 }
 
 func (job *edhrecCmdrDailyJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
@@ -176,18 +188,13 @@ func (job *edhrecCmdrDailyJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron)
 		"rankInfo":  curCmdr.rankInfo,
 		"saltScore": curCmdr.salt}).Debug("scrapped edhrec cmdr")
 
-	{
-		req := mtgbulk.NewNamesRequest()
-		req.Cards[curCmdr.cardname] = 1
-		resp, err := mtgbulk.ProcessByNames(req)
+	if c, _, found := job.cards.FindName(curCmdr.cardname, maxCandidates); found {
+		prices, err := job.prices.Prices(c)
 		if err != nil {
 			log.WithFields(log.Fields{"err": err}).Error("Unable to get prices")
-			return
+		} else {
+			curCmdr.minPrice, _ = prices.Min(currencyRUB)
 		}
-		minPriceRes := resp.MinPricesNoDelivery[curCmdr.cardname][0]
-		curCmdr.minPrice.Price = int(minPriceRes.Price)
-		curCmdr.minPrice.Seller = minPriceRes.Trader
-		curCmdr.minPrice.URL = minPriceRes.URL
 	}
 
 	job.updates <- curCmdr
@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// currencyReset are /currency arguments switching back to original prices
+var currencyReset = map[string]bool{"off": true, "original": true, "none": true}
+
+// money returns the format of prices chosen for the chat
+func (h *findHandler) money(msg tgbotapi.Message) moneyFormat {
+	var user tgbotbase.UserID
+	if msg.From != nil {
+		user = tgbotbase.UserID(msg.From.ID)
+	}
+	return h.currencies.formatFor(h.props, user, tgbotbase.ChatID(msg.Chat.ID))
+}
+
+func (h *findHandler) handleCurrency(msg tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	cur := strings.ToUpper(arg)
+	text := ""
+	switch {
+	case arg == "":
+		if display := h.money(msg).display; display == "" {
+			text = "Prices are shown in currencies of their sources"
+		} else {
+			text = fmt.Sprintf("Prices are shown in %s", display)
+		}
+		text = fmt.Sprintf("%s\nUse /currency <code> to change it, e.g. /currency usd, or /currency off to show original prices", text)
+	case currencyReset[strings.ToLower(arg)]:
+		cur = ""
+		fallthrough
+	case h.currencies.Known(cur):
+		if err := h.props.SetPropertyForChat(displayCurrencyProperty, tgbotbase.ChatID(msg.Chat.ID), cur); err != nil {
+			log.WithFields(log.Fields{"chat": msg.Chat.ID, "currency": cur, "err": err}).Error("cannot set display currency")
+			text = "Could not change the currency, please try again later"
+		} else if cur == "" {
+			text = "Prices will be shown in currencies of their sources"
+		} else {
+			text = fmt.Sprintf("Prices will be shown in %s with original prices in parentheses", cur)
+		}
+	default:
+		text = fmt.Sprintf("Unknown currency %q, use codes like rub, usd or eur", arg)
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
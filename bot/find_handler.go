@@ -1,18 +1,13 @@
 package bot
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"path"
 	"regexp"
 	"strings"
 
 	"github.com/admirallarimda/tgbotbase"
-	"github.com/ilyalavrinov/mtgbulkbuy/pkg/mtgbulk"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	"github.com/ilyalavrinov/tgbot-mtg/comprules"
 	log "github.com/sirupsen/logrus"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
@@ -20,134 +15,66 @@ import (
 type findHandler struct {
 	tgbotbase.BaseHandler
 
-	cardsDir string
-	cache    *PicCache
+	cards      *carddb.CardDB
+	cache      *PicCache
+	props      tgbotbase.PropertyStorage
+	prices     *PriceCache
+	currencies *Currencies
+	rulings    *rulingsCache
+	rules      *comprules.RulesDB
+	photos     *PhotoSender
 
-	cardsByID   map[string]Card
-	cardsByName map[string]Card
+	searches map[int64]*searchResult // chat -> last search
 }
 
-const dumpFilename = "all.dump.json"
+// maxCandidates limits the number of suggestions shown for an ambiguous card name
+const maxCandidates = 5
 
 var _ tgbotbase.IncomingMessageHandler = &findHandler{}
 
-func NewFindHandler(cardsDir string, cache *PicCache) tgbotbase.IncomingMessageHandler {
+func NewFindHandler(cards *carddb.CardDB, cache *PicCache, props tgbotbase.PropertyStorage, prices *PriceCache, currencies *Currencies, rules *comprules.RulesDB, photos *PhotoSender) tgbotbase.IncomingMessageHandler {
 	h := findHandler{
-		cardsDir:    cardsDir,
-		cache:       cache,
-		cardsByID:   make(map[string]Card),
-		cardsByName: make(map[string]Card),
+		cards:      cards,
+		cache:      cache,
+		props:      props,
+		prices:     prices,
+		currencies: currencies,
+		rulings:    newRulingsCache(),
+		rules:      rules,
+		photos:     photos,
+		searches:   make(map[int64]*searchResult),
 	}
 	return &h
 }
 
-func loadDump(dumpPath string) error {
-	const url = "https://archive.scryfall.com/json/scryfall-all-cards.json"
-	log.WithFields(log.Fields{"url": url, "dumpFile": dumpPath}).Info("loading new dump")
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Create the file
-	dumpTmp := dumpPath + ".tmp"
-	out, err := os.Create(dumpTmp)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-
-	if err := os.Rename(dumpTmp, dumpPath); err != nil {
-		return err
-	}
-	log.WithFields(log.Fields{"dumpFile": dumpPath}).Info("new dump has been downloaded")
-	return nil
-}
-
-type Images struct {
-	Normal string `json:"normal"`
-}
-type Card struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	LocalName   string `json:"printed_name"`
-	Lang        string `json:"lang"`
-	ImageURIs   Images `json:"image_uris"`
-	URI         string `json:"uri"`
-	RulingsURI  string `json:"rulings_uri"`
-	ScryfallURI string `json:"scryfall_uri"`
-}
-
 var re = regexp.MustCompile("(?U)\\[{2}(.*)\\]{2}")
 
 func (h *findHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
-	dumpPath := path.Join(h.cardsDir, dumpFilename)
-	os.MkdirAll(h.cardsDir, os.ModePerm)
-	if _, err := os.Stat(dumpPath); os.IsNotExist(err) {
-		log.WithFields(log.Fields{"dumpPath": dumpPath}).Info("dump is absent, loading")
-		if err := loadDump(dumpPath); err != nil {
-			panic(err)
-		}
-
-	}
-	f, err := os.Open(dumpPath)
-	if err != nil {
-		panic(err)
-	}
-	log.WithFields(log.Fields{"dumpPath": dumpPath}).Info("decoding dump")
-	dec := json.NewDecoder(f)
-	_, err = dec.Token()
-	if err != nil {
-		panic(err)
-	}
-	for dec.More() {
-		var c Card
-		err := dec.Decode(&c)
-		if err != nil {
-			panic(err)
-		}
-		if c.LocalName == "" {
-			c.LocalName = c.Name
-		}
-		h.cardsByID[c.ID] = c
-		names := []string{c.Name, c.LocalName}
-		if strings.Contains(c.Name, "//") {
-			names = []string{}
-			names = append(names, strings.Split(c.Name, " // ")...)
-			names = append(names, strings.Split(c.LocalName, " // ")...)
-		}
-		for _, n := range names {
-			n := strings.ToLower(n)
-			_, found := h.cardsByName[n]
-			if found {
-				if c.Lang == "en" {
-					h.cardsByName[n] = c
-				}
-			} else {
-				h.cardsByName[n] = c
-			}
-		}
-	}
-	_, err = dec.Token()
-	if err != nil {
-		panic(err)
-	}
-	log.WithFields(log.Fields{"cardsByID": len(h.cardsByID), "cardsByName": len(h.cardsByName)}).Info("decoding done")
-
 	h.OutMsgCh = outMsgCh
-	return tgbotbase.NewHandlerTrigger(re, []string{"find"})
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *findHandler) trigger() (*regexp.Regexp, []string) {
+	return re, []string{"find", "more", "lang", "currency", "rule"}
 }
 
 func (h *findHandler) HandleOne(msg tgbotapi.Message) {
 	reqs := []string{}
 	if msg.IsCommand() {
+		switch msg.Command() {
+		case "more":
+			h.handleMore(msg)
+			return
+		case "lang":
+			h.handleLang(msg)
+			return
+		case "currency":
+			h.handleCurrency(msg)
+			return
+		case "rule":
+			h.handleRule(msg)
+			return
+		}
 		reqs = append(reqs, msg.CommandArguments())
 	} else {
 		matches := re.FindAllStringSubmatch(msg.Text, -1)
@@ -157,24 +84,47 @@ func (h *findHandler) HandleOne(msg tgbotapi.Message) {
 	}
 	log.WithFields(log.Fields{"req": reqs, "msg": msg.Text}).Info("message triggered")
 
+	lang := h.preferredLang(msg)
 	cardsNotFound := []string{}
-	cardsToShow := make(map[string]Card, 0)
-	cardsRulings := make(map[string]Card, 0)
+	cardsAmbiguous := make(map[string][]carddb.NameMatch, 0)
+	cardsToShow := make(map[string]carddb.Card, 0)
+	cardsRulings := make(map[string]carddb.Card, 0)
+	cardsPrices := make(map[string]carddb.Card, 0)
 	for _, req := range reqs {
 		req = strings.Trim(req, " \n\t[]")
-		cardname := strings.ToLower(strings.Trim(req, "$#"))
-		if req == "" || cardname == "" {
+		if req == "" {
+			continue
+		}
+		if carddb.IsSearchQuery(req) {
+			h.handleSearch(req, lang, msg)
 			continue
 		}
-		card, found := h.cardsByName[cardname]
+		cardReq := parseCardRequest(strings.Trim(req, "$#"))
+		cardReq.name = carddb.NormalizeName(cardReq.name)
+		if cardReq.name == "" {
+			continue
+		}
+		cardname := cardReq.key()
+		card, candidates, found := h.cards.FindName(cardReq.name, maxCandidates)
 		if !found {
-			cardsNotFound = append(cardsNotFound, cardname)
+			if len(candidates) == 0 {
+				cardsNotFound = append(cardsNotFound, cardname)
+			} else {
+				cardsAmbiguous[cardname] = candidates
+			}
 			continue
 		}
+		if cardReq.set != "" {
+			card, found = h.cards.Printing(card.OracleID, cardReq.set, cardReq.number, card.Lang)
+			if !found {
+				cardsNotFound = append(cardsNotFound, cardname)
+				continue
+			}
+		}
+		card = h.localize(card, lang)
 		switch string(req[0]) {
 		case "$":
-			// price is handled at a card req
-			//h.handlePrice(card, msg)
+			cardsPrices[cardname] = card
 		case "#":
 			cardsRulings[cardname] = card
 		default:
@@ -184,9 +134,28 @@ func (h *findHandler) HandleOne(msg tgbotapi.Message) {
 
 	h.handleCards(cardsToShow, msg)
 	h.handleRulings(cardsRulings, msg)
+	h.handlePrices(cardsPrices, msg)
+	h.handleAmbiguous(cardsAmbiguous, msg)
 	h.handleNotFound(cardsNotFound, msg)
 }
 
+func (h *findHandler) handleAmbiguous(ambiguous map[string][]carddb.NameMatch, msg tgbotapi.Message) {
+	if len(ambiguous) == 0 {
+		return
+	}
+
+	m := "Several cards match your request:"
+	for req, candidates := range ambiguous {
+		m = fmt.Sprintf("%s\n\n%s:", m, req)
+		for _, c := range candidates {
+			m = fmt.Sprintf("%s\n[[%s]]", m, c.Card.LocalName)
+		}
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, m)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
 func (h *findHandler) handleNotFound(notFound []string, msg tgbotapi.Message) {
 	if len(notFound) == 0 {
 		return
@@ -201,146 +170,42 @@ func (h *findHandler) handleNotFound(notFound []string, msg tgbotapi.Message) {
 	h.OutMsgCh <- reply
 }
 
-func (h *findHandler) handleCards(cards map[string]Card, msg tgbotapi.Message) {
+func (h *findHandler) handleCards(cards map[string]carddb.Card, msg tgbotapi.Message) {
 	for _, c := range cards {
 		h.handleCard(c, msg)
 	}
 }
 
-func (h *findHandler) handleCard(c Card, msg tgbotapi.Message) {
-	picPath, err := h.cache.Get(c.ID, c.ImageURIs.Normal)
-	if err != nil {
-		log.WithFields(log.Fields{"id": c.ID, "err": err, "picPath": picPath}).Error("unable to get a picture from cache")
-		return
-	}
-	picMsg := tgbotapi.NewPhotoUpload(int64(msg.Chat.ID), picPath)
+func (h *findHandler) handleCard(c carddb.Card, msg tgbotapi.Message) {
+	picMsg := tgbotapi.NewPhotoUpload(int64(msg.Chat.ID), nil)
 	picMsg.ParseMode = "MarkdownV2"
 	name := c.LocalName
 	name = escapeMarkdown(name)
 	caption := fmt.Sprintf("[%s](%s)", name, c.ScryfallURI)
-	prices, err := getPrices(c)
+	if c.Set != "" {
+		printing := fmt.Sprintf("%s (%s) #%s", c.SetName, strings.ToUpper(c.Set), c.CollectorNumber)
+		caption = fmt.Sprintf("%s\n%s", caption, escapeMarkdown(printing))
+	}
+	prices, err := h.prices.Prices(c)
 	if err == nil {
-		usdPriceEscaped := strings.ReplaceAll(prices.PricesScryfall.USD, ".", "\\.")
-		if usdPriceEscaped != "" {
-			caption = fmt.Sprintf("%s\n%s$", caption, usdPriceEscaped)
+		money := h.money(msg)
+		if usd, found := prices.Min(currencyUSD); found {
+			caption = fmt.Sprintf("%s\n%s", caption, escapeMarkdown(money.amount(usd.Price, usd.Currency)))
 		}
-		if prices.Price.Price != 0 {
-			caption = fmt.Sprintf("%s\n%s", caption, formatPrice("min", prices.Price))
+		if rub, found := prices.Min(currencyRUB); found {
+			caption = fmt.Sprintf("%s\n%s", caption, formatPrice("min", rub, money))
 		}
 	}
 	picMsg.Caption = caption
 	picMsg.ReplyToMessageID = msg.MessageID
 
-	h.OutMsgCh <- picMsg
-}
-
-type price struct {
-	Price  int
-	Seller string
-	URL    string
-}
-type cardPrices struct {
-	PricesScryfall struct {
-		USD     string
-		USDFoil string `json:"usd_foil"`
-		EUR     string
-	} `json:"prices"`
-	Price price
-}
-
-func getPrices(c Card) (cardPrices, error) {
-	var prices cardPrices
-
-	// scryfall
-	resp, err := http.Get(c.URI)
+	// the picture is downloaded only if Telegram does not have it yet
+	err = h.photos.send(h.OutMsgCh, c.ID, 0, picMsg, func() (string, error) {
+		return h.cache.Get(c.ID, c.ImageURL())
+	})
 	if err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot load info from card URI")
-		return prices, err
+		log.WithFields(log.Fields{"id": c.ID, "chat": msg.Chat.ID, "err": err}).Error("unable to send card picture")
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot read API response")
-		return prices, err
-	}
-
-	if err = json.Unmarshal(body, &prices); err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot unmarshal full card info")
-		return prices, err
-	}
-
-	req := mtgbulk.NewNamesRequest()
-	req.Cards[c.LocalName] = 1
-	res, err := mtgbulk.ProcessByNames(req)
-	if err != nil {
-		log.WithFields(log.Fields{"cardName": c.LocalName, "err": err}).Error("cannot get min card prices")
-	} else {
-		cres := res.MinPricesNoDelivery[c.LocalName][0]
-		prices.Price.Price = int(cres.Price)
-		prices.Price.Seller = cres.Trader
-		prices.Price.URL = cres.URL
-	}
-
-	return prices, nil
-}
-
-func (h *findHandler) handlePrice(c Card, msg tgbotapi.Message) {
-	prices, err := getPrices(c)
-	if err != nil {
-		return
-	}
-
-	reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Prices for %q:\nUSD: %s\nUSD Foil: %s\nEUR: %s", c.LocalName, prices.PricesScryfall.USD, prices.PricesScryfall.USDFoil, prices.PricesScryfall.EUR))
-	reply.ReplyToMessageID = msg.MessageID
-	h.OutMsgCh <- reply
-}
-
-type ruling struct {
-	PublishedAt string `json:"published_at"`
-	Comment     string
-}
-type rulings struct {
-	Data []ruling
-}
-
-func (h *findHandler) handleRulings(cards map[string]Card, msg tgbotapi.Message) {
-	for _, c := range cards {
-		h.handleRulingsSingle(c, msg)
-	}
-}
-
-func (h *findHandler) handleRulingsSingle(c Card, msg tgbotapi.Message) {
-	resp, err := http.Get(c.RulingsURI)
-	if err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot load rulings")
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot read rulings")
-		return
-	}
-
-	var rules rulings
-	if err = json.Unmarshal(body, &rules); err != nil {
-		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.URI, "err": err}).Error("cannot unmarshal rulings")
-		return
-	}
-
-	replyTxt := ""
-	if len(rules.Data) == 0 {
-		replyTxt = fmt.Sprintf("Card %q does not have specific rulings", c.LocalName)
-	} else {
-		for _, d := range rules.Data {
-			replyTxt = fmt.Sprintf("%s%s: %s\n", replyTxt, d.PublishedAt, d.Comment)
-		}
-	}
-	reply := tgbotapi.NewMessage(msg.Chat.ID, replyTxt)
-	reply.ReplyToMessageID = msg.MessageID
-	h.OutMsgCh <- reply
 }
 
 func (h *findHandler) Name() string {
@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const cardLangProperty = "cardLang"
+
+// cardLangs are languages Scryfall has printings in
+var cardLangs = map[string]string{
+	"en":  "English",
+	"es":  "Spanish",
+	"fr":  "French",
+	"de":  "German",
+	"it":  "Italian",
+	"pt":  "Portuguese",
+	"ja":  "Japanese",
+	"ko":  "Korean",
+	"ru":  "Russian",
+	"zhs": "Simplified Chinese",
+	"zht": "Traditional Chinese",
+}
+
+// preferredLang returns language chosen for the chat, empty if none has been set
+func (h *findHandler) preferredLang(msg tgbotapi.Message) string {
+	var user tgbotbase.UserID
+	if msg.From != nil {
+		user = tgbotbase.UserID(msg.From.ID)
+	}
+	lang, err := h.props.GetProperty(cardLangProperty, user, tgbotbase.ChatID(msg.Chat.ID))
+	if err != nil {
+		log.WithFields(log.Fields{"chat": msg.Chat.ID, "err": err}).Error("cannot get card language")
+		return ""
+	}
+	return lang
+}
+
+// localize switches the card to the printing in the language preferred in the chat
+func (h *findHandler) localize(c carddb.Card, lang string) carddb.Card {
+	if lang == "" {
+		return c
+	}
+	return h.cards.InLanguage(c, lang)
+}
+
+func (h *findHandler) handleLang(msg tgbotapi.Message) {
+	lang := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	text := ""
+	if lang == "" {
+		cur := h.preferredLang(msg)
+		if cur == "" {
+			text = "Cards are shown in the language they were requested in"
+		} else {
+			text = fmt.Sprintf("Cards are shown in %s", cardLangs[cur])
+		}
+		text = fmt.Sprintf("%s\nUse /lang <code> to change it, supported: %s", text, supportedLangs())
+	} else if _, found := cardLangs[lang]; !found {
+		text = fmt.Sprintf("Unknown language %q, supported: %s", lang, supportedLangs())
+	} else if err := h.props.SetPropertyForChat(cardLangProperty, tgbotbase.ChatID(msg.Chat.ID), lang); err != nil {
+		log.WithFields(log.Fields{"chat": msg.Chat.ID, "lang": lang, "err": err}).Error("cannot set card language")
+		text = "Could not change the language, please try again later"
+	} else {
+		text = fmt.Sprintf("Cards will be shown in %s when there is such printing, otherwise in English", cardLangs[lang])
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func supportedLangs() string {
+	langs := make([]string, 0, len(cardLangs))
+	for l := range cardLangs {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return strings.Join(langs, ", ")
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// priceTopSellers is the number of sellers shown in a price reply
+	priceTopSellers = 10
+	// priceOffersPerSeller is the number of offers shown for every seller
+	priceOffersPerSeller = 3
+)
+
+func (h *findHandler) handlePrices(cards map[string]carddb.Card, msg tgbotapi.Message) {
+	for _, c := range cards {
+		h.handlePrice(c, msg)
+	}
+}
+
+func (h *findHandler) handlePrice(c carddb.Card, msg tgbotapi.Message) {
+	prices, err := h.prices.Prices(c)
+	if err != nil {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Could not get prices for %q", c.LocalName))
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+		return
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, formatCardPrices(c, prices, h.money(msg)))
+	reply.ParseMode = "MarkdownV2"
+	reply.DisableWebPagePreview = true
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func formatCardPrices(c carddb.Card, prices CardPrices, money moneyFormat) string {
+	lines := []string{fmt.Sprintf("Prices for [%s](%s):", escapeMarkdown(c.LocalName), c.ScryfallURI)}
+
+	scryfall := []string{}
+	for _, cur := range []string{currencyUSD, currencyEUR, currencyTIX} {
+		for _, o := range prices.InCurrency(cur) {
+			if o.Source != scryfallProviderName {
+				continue
+			}
+			p := money.amount(o.Price, o.Currency)
+			if o.Foil {
+				p += " foil"
+			}
+			scryfall = append(scryfall, p)
+		}
+	}
+	if len(scryfall) > 0 {
+		lines = append(lines, escapeMarkdown("Scryfall: "+strings.Join(scryfall, " / ")))
+	}
+
+	rub := prices.InCurrency(currencyRUB)
+	if len(rub) == 0 {
+		lines = append(lines, escapeMarkdown("Russian stores: no offers"))
+		return strings.Join(lines, "\n")
+	}
+	// foil copies cost more and are summarized separately not to push the average up
+	if regular := withFoil(rub, false); len(regular) > 0 {
+		lines = append(lines, escapeMarkdown(fmt.Sprintf("Russian stores: min %s, avg %s (%d offers)", money.amount(regular[0].Price, currencyRUB), money.amount(prices.Avg(currencyRUB, false), currencyRUB), len(regular))))
+	}
+	if foil := withFoil(rub, true); len(foil) > 0 {
+		lines = append(lines, escapeMarkdown(fmt.Sprintf("Russian stores foil: min %s, avg %s (%d offers)", money.amount(foil[0].Price, currencyRUB), money.amount(prices.Avg(currencyRUB, true), currencyRUB), len(foil))))
+	}
+	sellers := prices.offersBySeller(currencyRUB, priceOffersPerSeller)
+	if len(sellers) > priceTopSellers {
+		sellers = sellers[:priceTopSellers]
+	}
+	for _, so := range sellers {
+		offers := make([]string, 0, len(so.offers))
+		for _, o := range so.offers {
+			offer := money.amount(o.Price, o.Currency)
+			if o.Quantity > 1 {
+				offer = fmt.Sprintf("%s x%d", offer, o.Quantity)
+			}
+			if o.Foil {
+				offer += " foil"
+			}
+			offers = append(offers, offer)
+		}
+		lines = append(lines, fmt.Sprintf("[%s](%s): %s", escapeMarkdown(so.seller), so.offers[0].URL, escapeMarkdown(strings.Join(offers, ", "))))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cardRequest is a single card asked in a message, set and collector number choose a specific printing
+type cardRequest struct {
+	name   string
+	set    string
+	number string
+}
+
+var (
+	// Lightning Bolt|M10 or Lightning Bolt|M10|146
+	pipeRequestRe = regexp.MustCompile(`^(.+?)\s*\|\s*([0-9A-Za-z]+)\s*(?:\|\s*(\S+))?$`)
+	// Sol Ring (C21) 263, as decklists are exported by Arena
+	parenRequestRe = regexp.MustCompile(`^(.+?)\s*\(([0-9A-Za-z]+)\)\s*(\S+)?$`)
+)
+
+func parseCardRequest(req string) cardRequest {
+	for _, re := range []*regexp.Regexp{pipeRequestRe, parenRequestRe} {
+		if m := re.FindStringSubmatch(req); m != nil {
+			return cardRequest{
+				name:   m[1],
+				set:    strings.ToLower(m[2]),
+				number: strings.ToLower(m[3]),
+			}
+		}
+	}
+	return cardRequest{name: req}
+}
+
+// key identifies the request among others in the same message
+func (r cardRequest) key() string {
+	k := r.name
+	if r.set != "" {
+		k += " (" + r.set + ")"
+	}
+	if r.number != "" {
+		k += " " + r.number
+	}
+	return k
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ilyalavrinov/tgbot-mtg/comprules"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// ruleSearchLimit is the number of rules shown when a term is not in the glossary
+	ruleSearchLimit = 10
+	// sectionRuleLen truncates rules listed for a whole section, they are asked one by one for details
+	sectionRuleLen = 120
+)
+
+var (
+	ruleNumberRe = regexp.MustCompile(`^\d{3}(\.\d+[a-z]?)?\.?$`)
+	// ruleRefRe finds references like "See rule 702.19" in glossary entries
+	ruleRefRe = regexp.MustCompile(`rules? (\d{3}\.\d+[a-z]?)`)
+)
+
+func (h *findHandler) handleRule(msg tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	var lines []string
+	switch {
+	case h.rules == nil:
+		lines = []string{escapeMarkdown("Comprehensive rules are not available")}
+	case arg == "":
+		lines = []string{escapeMarkdown("Use /rule <number> like /rule 702.19 or /rule <term> like /rule trample")}
+	case ruleNumberRe.MatchString(strings.ToLower(arg)):
+		lines = h.ruleByNumber(arg)
+	default:
+		lines = h.ruleByTerm(arg)
+	}
+
+	for _, text := range splitMessage(lines) {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+		reply.ParseMode = "MarkdownV2"
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+	}
+}
+
+func (h *findHandler) ruleByNumber(number string) []string {
+	r, subrules, found := h.rules.Rule(number)
+	if !found {
+		return []string{escapeMarkdown(fmt.Sprintf("Rule %s is not found", number))}
+	}
+	section := !strings.Contains(r.Number, ".")
+	lines := formatRule(r, false)
+	for _, sub := range subrules {
+		lines = append(lines, formatRule(sub, section)...)
+	}
+	return lines
+}
+
+func (h *findHandler) ruleByTerm(term string) []string {
+	entry, found := h.rules.Glossary(term)
+	if !found {
+		rules := h.rules.Search(term, ruleSearchLimit)
+		if len(rules) == 0 {
+			return []string{escapeMarkdown(fmt.Sprintf("Nothing is found for %q in the comprehensive rules", term))}
+		}
+		lines := []string{escapeMarkdown(fmt.Sprintf("%q is not in the glossary, rules mentioning it:", term))}
+		for _, r := range rules {
+			lines = append(lines, formatRule(r, true)...)
+		}
+		return lines
+	}
+
+	lines := []string{fmt.Sprintf("*%s*", escapeMarkdown(entry.Term))}
+	for _, l := range strings.Split(entry.Text, "\n") {
+		lines = append(lines, escapeMarkdown(l))
+	}
+	seen := make(map[string]bool)
+	for _, m := range ruleRefRe.FindAllStringSubmatch(entry.Text, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		if ref := h.ruleByNumber(m[1]); len(ref) > 0 {
+			lines = append(lines, "")
+			lines = append(lines, ref...)
+		}
+	}
+	return lines
+}
+
+// formatRule shows the rule with its number in bold, brief rules are cut to their first line
+func formatRule(r comprules.Rule, brief bool) []string {
+	number := r.Number
+	if !strings.ContainsAny(number[len(number)-1:], "abcdefghijklmnopqrstuvwxyz") {
+		number += "."
+	}
+	text := r.Text
+	if brief {
+		text = strings.SplitN(text, "\n", 2)[0]
+		if runes := []rune(text); len(runes) > sectionRuleLen {
+			text = string(runes[:sectionRuleLen]) + "…"
+		}
+	}
+	parts := strings.Split(text, "\n")
+	lines := []string{fmt.Sprintf("*%s* %s", escapeMarkdown(number), escapeMarkdown(parts[0]))}
+	for _, p := range parts[1:] {
+		lines = append(lines, "_"+escapeMarkdown(p)+"_")
+	}
+	return lines
+}
@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// rulingsTTL is how long rulings are cached, they are published a few times a year
+const rulingsTTL = 24 * time.Hour
+
+var rulingsClient = &http.Client{Timeout: 20 * time.Second}
+
+type ruling struct {
+	Source      string
+	PublishedAt string `json:"published_at"`
+	Comment     string
+}
+type rulings struct {
+	Data []ruling
+}
+
+type cachedRulings struct {
+	rulings []ruling
+	loaded  time.Time
+}
+
+// rulingsCache keeps rulings per oracle id as every printing of a card shares them
+type rulingsCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedRulings
+}
+
+func newRulingsCache() *rulingsCache {
+	return &rulingsCache{entries: make(map[string]cachedRulings)}
+}
+
+func (rc *rulingsCache) get(c carddb.Card) ([]ruling, error) {
+	rc.mu.Lock()
+	e, found := rc.entries[c.OracleID]
+	rc.mu.Unlock()
+	if found && time.Since(e.loaded) < rulingsTTL {
+		return e.rulings, nil
+	}
+
+	loaded, err := loadRulings(c.RulingsURI)
+	if err != nil {
+		if found {
+			log.WithFields(log.Fields{"cardID": c.ID, "err": err}).Warn("outdated rulings are used")
+			return e.rulings, nil
+		}
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	for id, e := range rc.entries {
+		if now.Sub(e.loaded) >= rulingsTTL {
+			delete(rc.entries, id)
+		}
+	}
+	rc.entries[c.OracleID] = cachedRulings{rulings: loaded, loaded: now}
+	return loaded, nil
+}
+
+func loadRulings(uri string) ([]ruling, error) {
+	resp, err := rulingsClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scryfall has answered %s", resp.Status)
+	}
+
+	var rules rulings
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rules.Data, func(i, j int) bool {
+		return rules.Data[i].PublishedAt < rules.Data[j].PublishedAt
+	})
+	return rules.Data, nil
+}
+
+func (h *findHandler) handleRulings(cards map[string]carddb.Card, msg tgbotapi.Message) {
+	for _, c := range cards {
+		h.handleRulingsSingle(c, msg)
+	}
+}
+
+func (h *findHandler) handleRulingsSingle(c carddb.Card, msg tgbotapi.Message) {
+	rules, err := h.rulings.get(c)
+	if err != nil {
+		log.WithFields(log.Fields{"cardID": c.ID, "URI": c.RulingsURI, "err": err}).Error("cannot load rulings")
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Could not get rulings for %q", c.LocalName))
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+		return
+	}
+
+	for _, text := range splitMessage(formatRulings(c, rules)) {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+		reply.ParseMode = "MarkdownV2"
+		reply.DisableWebPagePreview = true
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+	}
+}
+
+// formatRulings shows oracle text of the card followed by its rulings grouped by publication date,
+// rulings are expected to be sorted by date
+func formatRulings(c carddb.Card, rules []ruling) []string {
+	lines := []string{fmt.Sprintf("*[%s](%s)*", escapeMarkdown(c.LocalName), c.ScryfallURI), formatCardInfo(c)}
+	if oracle := c.FullOracleText(); oracle != "" {
+		lines = append(lines, "")
+		for _, l := range strings.Split(oracle, "\n") {
+			if l == "" {
+				continue
+			}
+			lines = append(lines, "_"+escapeMarkdown(l)+"_")
+		}
+	}
+
+	lines = append(lines, "")
+	if len(rules) == 0 {
+		lines = append(lines, escapeMarkdown("The card does not have specific rulings"))
+		return lines
+	}
+
+	date := ""
+	for _, r := range rules {
+		if r.PublishedAt != date {
+			date = r.PublishedAt
+			lines = append(lines, fmt.Sprintf("*%s*", escapeMarkdown(date)))
+		}
+		comment := "• " + escapeMarkdown(r.Comment)
+		if r.Source == "scryfall" {
+			comment += " _\\(Scryfall\\)_"
+		}
+		lines = append(lines, comment)
+	}
+	return lines
+}
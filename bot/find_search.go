@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// searchPageSize is the number of cards listed in a single search reply
+const searchPageSize = 10
+
+type searchResult struct {
+	query string
+	cards []carddb.Card
+	page  int
+}
+
+func (r *searchResult) pages() int {
+	return (len(r.cards) + searchPageSize - 1) / searchPageSize
+}
+
+func (h *findHandler) handleSearch(query, lang string, msg tgbotapi.Message) {
+	cards, err := h.cards.Search(query)
+	if err != nil {
+		log.WithFields(log.Fields{"query": query, "err": err}).Info("cannot parse search query")
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Could not understand query %q: %s", query, err))
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+		return
+	}
+	log.WithFields(log.Fields{"query": query, "found": len(cards)}).Info("search done")
+
+	switch len(cards) {
+	case 0:
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("No cards match %q", query))
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+	case 1:
+		h.handleCard(h.localize(cards[0], lang), msg)
+	default:
+		res := &searchResult{query: query, cards: cards}
+		h.searches[msg.Chat.ID] = res
+		h.sendSearchPage(res, msg)
+	}
+}
+
+func (h *findHandler) handleMore(msg tgbotapi.Message) {
+	res, found := h.searches[msg.Chat.ID]
+	if !found || res.page+1 >= res.pages() {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "There are no more search results")
+		reply.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+		return
+	}
+	res.page++
+	h.sendSearchPage(res, msg)
+}
+
+func (h *findHandler) sendSearchPage(res *searchResult, msg tgbotapi.Message) {
+	from := res.page * searchPageSize
+	to := from + searchPageSize
+	if to > len(res.cards) {
+		to = len(res.cards)
+	}
+
+	lines := make([]string, 0, searchPageSize+2)
+	lines = append(lines, escapeMarkdown(fmt.Sprintf("%d cards match %q, page %d/%d:", len(res.cards), res.query, res.page+1, res.pages())))
+	for _, c := range res.cards[from:to] {
+		line := fmt.Sprintf("[%s](%s)", escapeMarkdown(c.Name), c.ScryfallURI)
+		if cost := c.FullManaCost(); cost != "" {
+			line = fmt.Sprintf("%s %s", line, escapeMarkdown(cost))
+		}
+		line = fmt.Sprintf("%s \\- %s", line, escapeMarkdown(c.FullTypeLine()))
+		lines = append(lines, line)
+	}
+	if res.page+1 < res.pages() {
+		lines = append(lines, escapeMarkdown("/more for the next page"))
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, strings.Join(lines, "\n"))
+	reply.ParseMode = "MarkdownV2"
+	reply.DisableWebPagePreview = true
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
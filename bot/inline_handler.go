@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"strconv"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// InlineQueryHandler answers inline queries like "@mtgbot bolt"
+type InlineQueryHandler interface {
+	HandleInline(q tgbotapi.InlineQuery)
+	Name() string
+}
+
+const (
+	// inlinePageSize is the number of pictures returned per inline request, Telegram allows up to 50
+	inlinePageSize = 20
+	// inlineMaxResults limits how deep user can scroll through results
+	inlineMaxResults = 200
+	inlineCacheTime  = 300
+)
+
+// inlineCachedPhoto is a picture already uploaded to Telegram, tgbotapi has no type for it
+type inlineCachedPhoto struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	FileID      string `json:"photo_file_id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type inlineHandler struct {
+	api    *tgbotapi.BotAPI
+	cards  *carddb.CardDB
+	props  tgbotbase.PropertyStorage
+	photos *PhotoSender
+}
+
+var _ InlineQueryHandler = &inlineHandler{}
+
+// NewInlineHandler answers inline queries with card pictures. Pictures from PicCache which have been
+// uploaded already are answered with their file ids, Telegram downloads others from Scryfall
+func NewInlineHandler(api *tgbotapi.BotAPI, cards *carddb.CardDB, props tgbotbase.PropertyStorage, photos *PhotoSender) InlineQueryHandler {
+	return &inlineHandler{
+		api:    api,
+		cards:  cards,
+		props:  props,
+		photos: photos,
+	}
+}
+
+func (h *inlineHandler) HandleInline(q tgbotapi.InlineQuery) {
+	offset, _ := strconv.Atoi(q.Offset)
+	lang := ""
+	if q.From != nil {
+		var err error
+		lang, err = h.props.GetProperty(cardLangProperty, tgbotbase.UserID(q.From.ID), tgbotbase.ChatID(q.From.ID))
+		if err != nil {
+			log.WithFields(log.Fields{"user": q.From.ID, "err": err}).Error("cannot get card language")
+		}
+	}
+
+	cards := h.find(q.Query)
+	log.WithFields(log.Fields{"query": q.Query, "offset": offset, "found": len(cards)}).Info("inline query")
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: q.ID,
+		CacheTime:     inlineCacheTime,
+		IsPersonal:    lang != "",
+		Results:       make([]interface{}, 0, inlinePageSize),
+	}
+	if offset < len(cards) {
+		to := offset + inlinePageSize
+		if to > len(cards) {
+			to = len(cards)
+		} else {
+			answer.NextOffset = strconv.Itoa(to)
+		}
+		for _, c := range cards[offset:to] {
+			if lang != "" {
+				c = h.cards.InLanguage(c, lang)
+			}
+			answer.Results = append(answer.Results, h.result(c)...)
+		}
+	}
+
+	if _, err := h.api.AnswerInlineQuery(answer); err != nil {
+		log.WithFields(log.Fields{"query": q.Query, "err": err}).Error("cannot answer inline query")
+	}
+}
+
+// result returns the picture of the card, nothing if the card has none
+func (h *inlineHandler) result(c carddb.Card) []interface{} {
+	if id := h.photos.fileID(c.ID); id != "" {
+		return []interface{}{inlineCachedPhoto{
+			Type:        "photo",
+			ID:          c.ID,
+			FileID:      id,
+			Title:       c.LocalName,
+			Description: c.FullTypeLine(),
+		}}
+	}
+	if c.ImageURL() == "" {
+		return nil
+	}
+	photo := tgbotapi.NewInlineQueryResultPhotoWithThumb(c.ID, c.ImageURL(), c.ThumbURL())
+	photo.Title = c.LocalName
+	photo.Description = c.FullTypeLine()
+	return []interface{}{photo}
+}
+
+// find returns cards matching search syntax or resembling the name
+func (h *inlineHandler) find(query string) []carddb.Card {
+	if query == "" {
+		return nil
+	}
+	if carddb.IsSearchQuery(query) {
+		cards, err := h.cards.Search(query)
+		if err != nil {
+			log.WithFields(log.Fields{"query": query, "err": err}).Info("cannot parse inline search query")
+			return nil
+		}
+		if len(cards) > inlineMaxResults {
+			cards = cards[:inlineMaxResults]
+		}
+		return cards
+	}
+
+	matches := h.cards.SuggestNames(query, inlineMaxResults)
+	cards := make([]carddb.Card, 0, len(matches))
+	for _, m := range matches {
+		cards = append(cards, m.Card)
+	}
+	return cards
+}
+
+func (h *inlineHandler) Name() string {
+	return "Inline card search"
+}
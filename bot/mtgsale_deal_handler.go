@@ -6,6 +6,7 @@ import (
 
 	"github.com/admirallarimda/tgbotbase"
 	"github.com/gocolly/colly"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
 	log "github.com/sirupsen/logrus"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
@@ -18,8 +19,10 @@ type mtgsaleDealUpdate struct {
 
 type mtgSaleDealHandler struct {
 	tgbotbase.BaseHandler
-	props tgbotbase.PropertyStorage
-	cron  tgbotbase.Cron
+	props    tgbotbase.PropertyStorage
+	cron     tgbotbase.Cron
+	cards    *carddb.CardDB
+	delivery *DailyDelivery
 
 	updates chan mtgsaleDealUpdate
 }
@@ -27,10 +30,14 @@ type mtgSaleDealHandler struct {
 var _ tgbotbase.BackgroundMessageHandler = &mtgSaleDealHandler{}
 
 func NewMtgsaleDealHandler(cron tgbotbase.Cron,
-	props tgbotbase.PropertyStorage) tgbotbase.BackgroundMessageHandler {
+	props tgbotbase.PropertyStorage,
+	cards *carddb.CardDB,
+	delivery *DailyDelivery) tgbotbase.BackgroundMessageHandler {
 	h := &mtgSaleDealHandler{
-		props: props,
-		cron:  cron,
+		props:    props,
+		cron:     cron,
+		cards:    cards,
+		delivery: delivery,
 	}
 	h.updates = make(chan mtgsaleDealUpdate, 0)
 	return h
@@ -41,15 +48,6 @@ func (h *mtgSaleDealHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan
 }
 
 func (h *mtgSaleDealHandler) Run() {
-	chatsToNotify := make([]tgbotbase.ChatID, 0)
-	props, _ := h.props.GetEveryHavingProperty("mtgsaleDealNotify")
-	for _, prop := range props {
-		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
-			continue
-		}
-		chatsToNotify = append(chatsToNotify, prop.Chat)
-	}
-
 	prevDealName, err := h.props.GetProperty("mtgsaleDealLast", 0, 0)
 	if err != nil {
 		panic(fmt.Sprintf("Could not get last mtgsale deal, err: %s", err))
@@ -74,12 +72,14 @@ func (h *mtgSaleDealHandler) Run() {
 				}
 
 				text := fmt.Sprintf("Карта дня на mtgsale:\n[%s](%s)\n%s ~%s~", data.cardname, data.url, data.priceNew, data.priceOld)
-				for _, chatID := range chatsToNotify {
-					msg := tgbotapi.NewPhotoUpload(int64(chatID), picFName)
-					msg.Caption = text
-					msg.ParseMode = "MarkdownV2"
-					h.OutMsgCh <- msg
+				if c, _, found := h.cards.FindName(data.cardname, maxCandidates); found {
+					text = fmt.Sprintf("%s\n%s", text, formatCardInfo(c))
 				}
+				h.delivery.publish(dailyPost{
+					feed:    mtgsaleFeed,
+					pic:     picFName,
+					caption: func(tgbotbase.ChatID) string { return text },
+				})
 			}
 		}
 	}()
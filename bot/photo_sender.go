@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// photoFileIDPrefix keys Telegram file ids of uploaded pictures
+const photoFileIDPrefix = "mtgbot:fileid:"
+
+// PhotoSender remembers Telegram file ids of uploaded pictures and sends them by id afterwards.
+// Messages are sent directly through the api as file ids are known only from responses
+type PhotoSender struct {
+	api   *tgbotapi.BotAPI
+	redis *redis.Client
+}
+
+// NewPhotoSender creates a sender keeping file ids in the redis db, api may be nil
+// in which case every picture is uploaded as usual
+func NewPhotoSender(api *tgbotapi.BotAPI, client *redis.Client) *PhotoSender {
+	return &PhotoSender{api: api, redis: client}
+}
+
+// send delivers the photo of the local file identified by key, the file is asked for only if it has to be uploaded.
+// A known file id is tried first, the file is uploaded if there is none or Telegram says the id is not valid anymore.
+// File ids are kept for ttl, 0 keeps them forever
+func (s *PhotoSender) send(out chan<- tgbotapi.Chattable, key string, ttl time.Duration, photo tgbotapi.PhotoConfig, file func() (string, error)) error {
+	if s == nil || s.api == nil {
+		fpath, err := file()
+		if err != nil {
+			return err
+		}
+		photo.File = fpath
+		out <- photo
+		return nil
+	}
+
+	if id := s.fileID(key); id != "" {
+		shared := photo
+		shared.File = nil
+		shared.FileID = id
+		shared.UseExisting = true
+		_, err := s.api.Send(shared)
+		if err == nil {
+			return nil
+		}
+		if !fileIDRejected(err) {
+			return err
+		}
+		log.WithFields(log.Fields{"key": key, "err": err}).Warn("file id is rejected, uploading the picture")
+		s.redis.Del(photoFileIDPrefix + key)
+	}
+
+	fpath, err := file()
+	if err != nil {
+		return err
+	}
+	photo.File = fpath
+	photo.UseExisting = false
+	sent, err := s.api.Send(photo)
+	if err != nil {
+		return err
+	}
+	if sent.Photo == nil || len(*sent.Photo) == 0 {
+		return nil
+	}
+	// sizes are ordered from the smallest one, the largest is the original picture
+	sizes := *sent.Photo
+	if err := s.redis.Set(photoFileIDPrefix+key, sizes[len(sizes)-1].FileID, ttl).Err(); err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot remember file id")
+	}
+	return nil
+}
+
+// fileIDRejected tells whether Telegram does not accept the file id itself,
+// other errors such as network failures or flood limits do not mean the id is wrong
+func fileIDRejected(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	if !ok {
+		return false
+	}
+	msg := strings.ToLower(apiErr.Message)
+	return strings.Contains(msg, "file identifier") || strings.Contains(msg, "file_id") || strings.Contains(msg, "invalid file")
+}
+
+func (s *PhotoSender) fileID(key string) string {
+	if s == nil {
+		return ""
+	}
+	id, err := s.redis.Get(photoFileIDPrefix + key).Result()
+	if err != nil && err != redis.Nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot get file id")
+	}
+	return id
+}
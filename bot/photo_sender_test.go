@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestFileIDRejected(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{tgbotapi.Error{Message: "Bad Request: wrong file identifier/HTTP URL specified"}, true},
+		{tgbotapi.Error{Message: "Bad Request: wrong remote file identifier specified: can't unserialize it"}, true},
+		{tgbotapi.Error{Message: "Bad Request: invalid file_id"}, true},
+		{tgbotapi.Error{Message: "Too Many Requests: retry after 5", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}, false},
+		{tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}, false},
+		{errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, c := range cases {
+		if got := fileIDRejected(c.err); got != c.want {
+			t.Errorf("fileIDRejected(%q) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestPhotoSenderWithoutAPIUploads(t *testing.T) {
+	out := make(chan tgbotapi.Chattable, 1)
+	asked := 0
+	err := (*PhotoSender)(nil).send(out, "card", 0, tgbotapi.NewPhotoUpload(1, nil), func() (string, error) {
+		asked++
+		return "/cache/card.jpg", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := (<-out).(tgbotapi.PhotoConfig)
+	if photo.File != "/cache/card.jpg" || asked != 1 {
+		t.Errorf("picture is not uploaded from the file: %+v, asked %d times", photo, asked)
+	}
+
+	failed := errors.New("no picture")
+	if err := (*PhotoSender)(nil).send(out, "card", 0, tgbotapi.NewPhotoUpload(1, nil), func() (string, error) {
+		return "", failed
+	}); err != failed {
+		t.Errorf("file error is not returned: %v", err)
+	}
+	if len(out) != 0 {
+		t.Error("photo is sent without its file")
+	}
+}
@@ -1,57 +1,306 @@
 package bot
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang/groupcache/singleflight"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	picIndexFilename = "index.json"
+	// picIndexFlushPeriod limits how often access times are written to the index
+	picIndexFlushPeriod = time.Minute
+)
+
+// picEvictGrace protects pictures which Get has just returned, callers are likely still sending them.
+// The cache may exceed its limits for this time
+var picEvictGrace = time.Minute
+
+var picClient = &http.Client{Timeout: 30 * time.Second}
+
+// PicCacheConfig limits the cache, zero limits are not applied
+type PicCacheConfig struct {
+	Dir       string
+	MaxSizeMB int
+	MaxCount  int
+}
+
+type picEntry struct {
+	Size     int64     `json:"size"`
+	Accessed time.Time `json:"accessed"`
+}
+
+// PicCacheStats describes the cache since the start
+type PicCacheStats struct {
+	Hits, Misses, Evictions int
+	Count                   int
+	Size                    int64
+}
+
+// PicCache keeps card pictures on the disk. Pictures are downloaded to temporary files and renamed
+// once complete, so a cached file is never seen half-written, and concurrent requests of a picture share a download.
+// Least recently used pictures are removed when the cache exceeds its limits, access times are kept in an index file
 type PicCache struct {
-	dir string
+	cfg   PicCacheConfig
+	group singleflight.Group
+
+	// flushMu keeps index writes in order, it is taken before mu
+	flushMu sync.Mutex
+
+	mu        sync.Mutex
+	entries   map[string]*picEntry
+	size      int64
+	dirty     bool
+	flushedAt time.Time
+	stats     PicCacheStats
 }
 
-func NewPicCache(baseDir string) *PicCache {
-	if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
+func NewPicCache(cfg PicCacheConfig) *PicCache {
+	if err := os.MkdirAll(cfg.Dir, os.ModePerm); err != nil {
 		panic(err)
 	}
 
-	return &PicCache{
-		dir: baseDir,
+	c := &PicCache{
+		cfg:     cfg,
+		entries: make(map[string]*picEntry),
+	}
+	c.scan()
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	c.flush()
+	return c
+}
+
+// scan rebuilds the index from files in the directory keeping access times known from the index file
+func (c *PicCache) scan() {
+	indexPath := path.Join(c.cfg.Dir, picIndexFilename)
+	known := make(map[string]*picEntry)
+	if b, err := ioutil.ReadFile(indexPath); err == nil {
+		if err := json.Unmarshal(b, &known); err != nil {
+			log.WithFields(log.Fields{"indexPath": indexPath, "err": err}).Warn("cannot read picture cache index, rebuilding it")
+		}
+	}
+
+	files, err := ioutil.ReadDir(c.cfg.Dir)
+	if err != nil {
+		log.WithFields(log.Fields{"dir": c.cfg.Dir, "err": err}).Error("cannot scan picture cache")
+		return
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || name == picIndexFilename {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// left by an interrupted download
+			os.Remove(path.Join(c.cfg.Dir, name))
+			continue
+		}
+		e := &picEntry{Size: f.Size(), Accessed: f.ModTime()}
+		if k, found := known[name]; found && k.Accessed.After(e.Accessed) {
+			e.Accessed = k.Accessed
+		}
+		c.entries[name] = e
+		c.size += e.Size
 	}
+	c.dirty = true
+	log.WithFields(log.Fields{"dir": c.cfg.Dir, "count": len(c.entries), "size": c.size}).Info("picture cache is scanned")
 }
 
 func (c *PicCache) Get(id, url string) (string, error) {
-	fpath := path.Join(c.dir, string(id))
-	_, err := os.Stat(fpath)
-	if os.IsNotExist(err) {
+	fpath := path.Join(c.cfg.Dir, id)
+	if c.touch(id) {
+		return fpath, nil
+	}
+
+	p, err := c.group.Do(id, func() (interface{}, error) {
+		// the picture might have been loaded by a download which has just finished
+		if c.touch(id) {
+			return fpath, nil
+		}
+		c.mu.Lock()
+		c.stats.Misses++
+		c.mu.Unlock()
 		return c.load(id, url)
+	})
+	if err != nil {
+		return "", err
 	}
-	return fpath, nil
+	return p.(string), nil
+}
+
+// touch marks the picture as used, false if it is not cached
+func (c *PicCache) touch(id string) bool {
+	c.mu.Lock()
+	e, found := c.entries[id]
+	if !found {
+		c.mu.Unlock()
+		return false
+	}
+	if _, err := os.Stat(path.Join(c.cfg.Dir, id)); err != nil {
+		// removed by someone else
+		c.size -= e.Size
+		delete(c.entries, id)
+		c.dirty = true
+		c.mu.Unlock()
+		return false
+	}
+	c.stats.Hits++
+	e.Accessed = time.Now()
+	c.dirty = true
+	needFlush := time.Since(c.flushedAt) > picIndexFlushPeriod
+	if needFlush {
+		// other hits should not start flushing too
+		c.flushedAt = time.Now()
+	}
+	c.mu.Unlock()
+
+	if needFlush {
+		go c.flush()
+	}
+	return true
 }
 
 func (c *PicCache) load(id, url string) (string, error) {
 	log.WithFields(log.Fields{"id": id, "url": url}).Info("loading missing picture")
-	resp, err := http.Get(url)
+	resp, err := picClient.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected picture response status: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+		return "", fmt.Errorf("unexpected picture content type %q", ct)
+	}
 
-	fpath := path.Join(c.dir, string(id))
-	out, err := os.Create(fpath)
+	tmp, err := ioutil.TempFile(c.cfg.Dir, id+".*.tmp")
 	if err != nil {
 		return "", err
 	}
-	defer out.Close()
+	defer os.Remove(tmp.Name())
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+	n, err := io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return "", err
 	}
+	if n == 0 || (resp.ContentLength >= 0 && n != resp.ContentLength) {
+		return "", fmt.Errorf("picture transfer incomplete: got %d of %d bytes", n, resp.ContentLength)
+	}
+
+	fpath := path.Join(c.cfg.Dir, id)
+	if err := os.Rename(tmp.Name(), fpath); err != nil {
+		return "", err
+	}
 
+	c.mu.Lock()
+	if old, found := c.entries[id]; found {
+		c.size -= old.Size
+	}
+	c.entries[id] = &picEntry{Size: n, Accessed: time.Now()}
+	c.size += n
+	c.dirty = true
+	c.evict()
+	c.mu.Unlock()
+
+	c.flush()
 	return fpath, nil
 }
+
+func (c *PicCache) overLimits() bool {
+	if c.cfg.MaxCount > 0 && len(c.entries) > c.cfg.MaxCount {
+		return true
+	}
+	return c.cfg.MaxSizeMB > 0 && c.size > int64(c.cfg.MaxSizeMB)*1024*1024
+}
+
+// evict removes least recently used pictures until the cache fits its limits. Pictures used during
+// picEvictGrace are never removed as they might be about to be sent. Must be called with mu held
+func (c *PicCache) evict() {
+	if !c.overLimits() {
+		return
+	}
+	ids := make([]string, 0, len(c.entries))
+	for id, e := range c.entries {
+		if time.Since(e.Accessed) >= picEvictGrace {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return c.entries[ids[i]].Accessed.Before(c.entries[ids[j]].Accessed)
+	})
+
+	evicted := 0
+	for _, id := range ids {
+		if !c.overLimits() {
+			break
+		}
+		if err := os.Remove(path.Join(c.cfg.Dir, id)); err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"id": id, "err": err}).Error("cannot evict picture")
+			continue
+		}
+		c.size -= c.entries[id].Size
+		delete(c.entries, id)
+		evicted++
+	}
+	c.stats.Evictions += evicted
+	c.dirty = true
+	log.WithFields(log.Fields{"evicted": evicted, "count": len(c.entries), "size": c.size}).Info("pictures are evicted from cache")
+}
+
+// flush writes the index if it has changed. The index is copied under mu and written without it,
+// so cache hits do not wait for the disk. Must be called without mu held
+func (c *PicCache) flush() {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	b, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.flushedAt = time.Now()
+	c.mu.Unlock()
+
+	indexPath := path.Join(c.cfg.Dir, picIndexFilename)
+	if err == nil {
+		tmp := indexPath + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0644); err == nil {
+			err = os.Rename(tmp, indexPath)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"indexPath": indexPath, "err": err}).Error("cannot write picture cache index")
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+}
+
+// Stats returns counters since the start together with the current size of the cache
+func (c *PicCache) Stats() PicCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Count = len(c.entries)
+	s.Size = c.size
+	return s
+}
@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPicture = bytes.Repeat([]byte("jpeg"), 1024)
+
+func testPicCache(t *testing.T, cfg PicCacheConfig) (*PicCache, func()) {
+	dir, err := ioutil.TempDir("", "piccache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Dir = dir
+	return NewPicCache(cfg), func() { os.RemoveAll(dir) }
+}
+
+// cachedFiles lists files of the cache directory except the index
+func cachedFiles(t *testing.T, c *PicCache) []string {
+	files, err := ioutil.ReadDir(c.cfg.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		if f.Name() != picIndexFilename {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+func TestPicCacheConcurrentGetDownloadsOnce(t *testing.T) {
+	var downloads int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testPicture)
+	}))
+	defer srv.Close()
+	c, cleanup := testPicCache(t, PicCacheConfig{})
+	defer cleanup()
+
+	const callers = 20
+	paths := make(chan string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := c.Get("bolt.jpg", srv.URL)
+			if err != nil {
+				t.Error(err)
+			}
+			paths <- p
+		}()
+	}
+	// let every caller reach the download before it finishes
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(paths)
+
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("picture is downloaded %d times", n)
+	}
+	for p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, testPicture) {
+			t.Errorf("%s has %d bytes, want %d", p, len(b), len(testPicture))
+		}
+	}
+	if _, err := c.Get("bolt.jpg", srv.URL); err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("cached picture is downloaded again")
+	}
+}
+
+func TestPicCacheHidesPartialDownload(t *testing.T) {
+	halfSent := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testPicture[:len(testPicture)/2])
+		w.(http.Flusher).Flush()
+		close(halfSent)
+		<-release
+		w.Write(testPicture[len(testPicture)/2:])
+	}))
+	defer srv.Close()
+	c, cleanup := testPicCache(t, PicCacheConfig{})
+	defer cleanup()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get("bolt.jpg", srv.URL)
+		done <- err
+	}()
+	<-halfSent
+	// give the cache time to write what it has received
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path.Join(c.cfg.Dir, "bolt.jpg")); !os.IsNotExist(err) {
+		t.Errorf("picture is visible before its download is complete, stat error %v", err)
+	}
+	for _, f := range cachedFiles(t, c) {
+		if !strings.HasSuffix(f, ".tmp") {
+			t.Errorf("unexpected file %s during download", f)
+		}
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(c.cfg.Dir, "bolt.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, testPicture) {
+		t.Errorf("picture has %d bytes, want %d", len(b), len(testPicture))
+	}
+	if files := cachedFiles(t, c); len(files) != 1 {
+		t.Errorf("temporary files are left: %q", files)
+	}
+}
+
+func TestPicCacheRejectsBadResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(testPicture)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>not a picture</html>"))
+		case "/truncated":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Length", "4096")
+			w.Write(testPicture[:100])
+		case "/empty":
+			w.Header().Set("Content-Type", "image/jpeg")
+		}
+	}))
+	defer srv.Close()
+	c, cleanup := testPicCache(t, PicCacheConfig{})
+	defer cleanup()
+
+	for _, name := range []string{"missing", "html", "truncated", "empty"} {
+		id := name + ".jpg"
+		if p, err := c.Get(id, srv.URL+"/"+name); err == nil {
+			t.Errorf("%s response is cached as %s", name, p)
+		}
+		if _, err := os.Stat(path.Join(c.cfg.Dir, id)); !os.IsNotExist(err) {
+			t.Errorf("%s response is kept on disk, stat error %v", name, err)
+		}
+	}
+	if files := cachedFiles(t, c); len(files) != 0 {
+		t.Errorf("files are left after failed downloads: %q", files)
+	}
+	if s := c.Stats(); s.Count != 0 || s.Size != 0 {
+		t.Errorf("failed downloads are counted in the cache: %+v", s)
+	}
+}
+
+func TestPicCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	grace := picEvictGrace
+	picEvictGrace = 0
+	defer func() { picEvictGrace = grace }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testPicture)
+	}))
+	defer srv.Close()
+	c, cleanup := testPicCache(t, PicCacheConfig{MaxCount: 2})
+	defer cleanup()
+
+	for _, id := range []string{"a.jpg", "b.jpg", "a.jpg", "c.jpg"} {
+		if _, err := c.Get(id, srv.URL); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	files := cachedFiles(t, c)
+	if len(files) != 2 || files[0] != "a.jpg" || files[1] != "c.jpg" {
+		t.Errorf("cache keeps %q, want a.jpg and c.jpg", files)
+	}
+	if s := c.Stats(); s.Evictions != 1 || s.Hits != 1 || s.Misses != 3 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestPicCacheKeepsRecentlyReturnedPictures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testPicture)
+	}))
+	defer srv.Close()
+	c, cleanup := testPicCache(t, PicCacheConfig{MaxCount: 1})
+	defer cleanup()
+
+	// every picture might still be sent by the caller which has got it
+	paths := []string{}
+	for _, id := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		p, err := c.Get(id, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("recently returned picture is evicted: %v", err)
+		}
+	}
+	if s := c.Stats(); s.Evictions != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/go-redis/redis"
+	"github.com/golang/groupcache/singleflight"
+	log "github.com/sirupsen/logrus"
+)
+
+// priceCacheRedisPrefix contains a version to be changed once layout of cached values changes
+const priceCacheRedisPrefix = "mtgbot:price:2:"
+
+// PriceCacheConfig sets how long prices are considered fresh and how long stale prices may still be shown
+type PriceCacheConfig struct {
+	TTL   time.Duration
+	Stale time.Duration
+
+	// RedisDB is the name of Redis DB keeping prices between restarts, memory only if empty
+	RedisDB string
+}
+
+type priceCacheEntry struct {
+	Value     json.RawMessage `json:"value"`
+	FetchedAt time.Time       `json:"fetched_at"`
+}
+
+// PriceCache keeps prices fetched from external sources keyed by source and card.
+// Concurrent requests for the same key share a single fetch, stale values are returned
+// immediately while being refreshed in background
+type PriceCache struct {
+	cfg       PriceCacheConfig
+	redis     *redis.Client
+	providers *PriceProviders
+	history   *PriceHistory
+
+	mu      sync.Mutex
+	entries map[string]priceCacheEntry
+
+	group singleflight.Group
+}
+
+// NewPriceCache creates a cache of prices got from providers, every price fetched is recorded to history unless it is nil
+func NewPriceCache(cfg PriceCacheConfig, pool tgbotbase.RedisPool, providers *PriceProviders, history *PriceHistory) *PriceCache {
+	c := &PriceCache{
+		cfg:       cfg,
+		providers: providers,
+		history:   history,
+		entries:   make(map[string]priceCacheEntry),
+	}
+	if cfg.RedisDB != "" {
+		c.redis = pool.GetConnByName(cfg.RedisDB)
+	}
+	return c
+}
+
+// get fills dst with the value stored under the key, fetch is called when there is no usable value
+func (c *PriceCache) get(key string, dst interface{}, fetch func() (interface{}, error)) error {
+	e, found := c.lookup(key)
+	age := time.Since(e.FetchedAt)
+	switch {
+	case found && age < c.cfg.TTL:
+		return json.Unmarshal(e.Value, dst)
+	case found && age < c.cfg.TTL+c.cfg.Stale:
+		log.WithFields(log.Fields{"key": key, "age": age}).Debug("serving stale price, refreshing")
+		go func() {
+			if _, err := c.group.Do(key, func() (interface{}, error) { return c.refresh(key, fetch) }); err != nil {
+				log.WithFields(log.Fields{"key": key, "err": err}).Error("background price refresh failed")
+			}
+		}()
+		return json.Unmarshal(e.Value, dst)
+	}
+
+	v, err := c.group.Do(key, func() (interface{}, error) { return c.refresh(key, fetch) })
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(v.(json.RawMessage), dst)
+}
+
+// fresh fills dst with the value stored under the key if it has not expired yet
+func (c *PriceCache) fresh(key string, dst interface{}) bool {
+	e, found := c.lookup(key)
+	if !found || time.Since(e.FetchedAt) >= c.cfg.TTL {
+		return false
+	}
+	return json.Unmarshal(e.Value, dst) == nil
+}
+
+func (c *PriceCache) refresh(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	return c.save(key, v)
+}
+
+// save puts a freshly fetched value to the cache
+func (c *PriceCache) save(key string, v interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, priceCacheEntry{Value: b, FetchedAt: time.Now()})
+	return json.RawMessage(b), nil
+}
+
+func (c *PriceCache) lookup(key string) (priceCacheEntry, bool) {
+	c.mu.Lock()
+	e, found := c.entries[key]
+	c.mu.Unlock()
+	if found || c.redis == nil {
+		return e, found
+	}
+
+	b, err := c.redis.Get(priceCacheRedisPrefix + key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot get price from redis")
+		}
+		return e, false
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot decode price from redis")
+		return e, false
+	}
+	c.mu.Lock()
+	c.entries[key] = e
+	c.mu.Unlock()
+	return e, true
+}
+
+func (c *PriceCache) store(key string, e priceCacheEntry) {
+	c.mu.Lock()
+	c.entries[key] = e
+	for k, old := range c.entries {
+		// dropping entries which cannot be served anymore to keep memory bounded
+		if time.Since(old.FetchedAt) > c.cfg.TTL+c.cfg.Stale {
+			delete(c.entries, k)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.redis == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot encode price for redis")
+		return
+	}
+	if err := c.redis.Set(priceCacheRedisPrefix+key, b, c.cfg.TTL+c.cfg.Stale).Err(); err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot store price in redis")
+	}
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	priceHistoryPrefix = "mtgbot:history:"
+	// priceHistoryKeep is how long observed prices are kept
+	priceHistoryKeep = 2 * 365 * 24 * time.Hour
+)
+
+// pricePoint is a single observation of a card price
+type pricePoint struct {
+	At time.Time
+	// Min and Avg are prices at russian stores
+	Min, Avg int
+	USD      float64
+}
+
+// PriceHistory keeps every observed price in Redis sorted sets scored by observation time.
+// Russian prices are kept per card name as stores do not distinguish printings, USD prices are kept per printing
+type PriceHistory struct {
+	redis *redis.Client
+}
+
+func NewPriceHistory(client *redis.Client) *PriceHistory {
+	return &PriceHistory{redis: client}
+}
+
+func ruHistoryKey(oracleID string) string {
+	return priceHistoryPrefix + "ru:" + oracleID
+}
+
+func usdHistoryKey(cardID string) string {
+	return priceHistoryPrefix + "usd:" + cardID
+}
+
+func (h *PriceHistory) recordRU(oracleID string, min, avg int, at time.Time) {
+	if h == nil || min == 0 {
+		return
+	}
+	h.record(ruHistoryKey(oracleID), fmt.Sprintf("%d:%d:%d", at.Unix(), min, avg), at)
+}
+
+func (h *PriceHistory) recordUSD(cardID string, usd float64, at time.Time) {
+	if h == nil || usd == 0 {
+		return
+	}
+	h.record(usdHistoryKey(cardID), fmt.Sprintf("%d:%.2f", at.Unix(), usd), at)
+}
+
+func (h *PriceHistory) record(key, member string, at time.Time) {
+	// observation time is a part of the member, so equal prices observed at different times are all kept
+	if err := h.redis.ZAdd(key, redis.Z{Score: float64(at.Unix()), Member: member}).Err(); err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot record price")
+		return
+	}
+	expired := strconv.FormatInt(at.Add(-priceHistoryKeep).Unix(), 10)
+	if err := h.redis.ZRemRangeByScore(key, "-inf", "("+expired).Err(); err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot remove old prices")
+	}
+}
+
+// ruSince returns russian prices of the card observed since the given time ordered by time
+func (h *PriceHistory) ruSince(oracleID string, since time.Time) ([]pricePoint, error) {
+	members, err := h.since(ruHistoryKey(oracleID), since)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]pricePoint, 0, len(members))
+	for _, m := range members {
+		parts := strings.Split(m, ":")
+		if len(parts) != 3 {
+			continue
+		}
+		ts, err1 := strconv.ParseInt(parts[0], 10, 64)
+		min, err2 := strconv.Atoi(parts[1])
+		avg, err3 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		points = append(points, pricePoint{At: time.Unix(ts, 0), Min: min, Avg: avg})
+	}
+	return points, nil
+}
+
+// usdSince returns Scryfall USD prices of the printing observed since the given time ordered by time
+func (h *PriceHistory) usdSince(cardID string, since time.Time) ([]pricePoint, error) {
+	members, err := h.since(usdHistoryKey(cardID), since)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]pricePoint, 0, len(members))
+	for _, m := range members {
+		parts := strings.Split(m, ":")
+		if len(parts) != 2 {
+			continue
+		}
+		ts, err1 := strconv.ParseInt(parts[0], 10, 64)
+		usd, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		points = append(points, pricePoint{At: time.Unix(ts, 0), USD: usd})
+	}
+	return points, nil
+}
+
+func (h *PriceHistory) since(key string, since time.Time) ([]string, error) {
+	members, err := h.redis.ZRangeByScore(key, redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "err": err}).Error("cannot get price history")
+	}
+	return members, err
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// priceHistoryDays is the period shown on the chart
+const priceHistoryDays = 180
+
+type priceHistoryHandler struct {
+	tgbotbase.BaseHandler
+
+	cards   *carddb.CardDB
+	history *PriceHistory
+}
+
+var _ tgbotbase.IncomingMessageHandler = &priceHistoryHandler{}
+
+func NewPriceHistoryHandler(cards *carddb.CardDB, history *PriceHistory) tgbotbase.IncomingMessageHandler {
+	return &priceHistoryHandler{
+		cards:   cards,
+		history: history,
+	}
+}
+
+func (h *priceHistoryHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *priceHistoryHandler) trigger() (*regexp.Regexp, []string) {
+	return nil, []string{"pricehistory"}
+}
+
+func (h *priceHistoryHandler) HandleOne(msg tgbotapi.Message) {
+	req := parseCardRequest(strings.TrimSpace(msg.CommandArguments()))
+	req.name = carddb.NormalizeName(req.name)
+	if req.name == "" {
+		h.reply(msg, "Usage: /pricehistory <card>, a printing can be chosen like /pricehistory Lightning Bolt|M10")
+		return
+	}
+	card, _, found := h.cards.FindName(req.name, maxCandidates)
+	if found && req.set != "" {
+		card, found = h.cards.Printing(card.OracleID, req.set, req.number, card.Lang)
+	}
+	if !found {
+		h.reply(msg, fmt.Sprintf("I could not recognize card %q", req.key()))
+		return
+	}
+	// Scryfall prices are observed for english printings
+	priced := card
+	if card.Lang != "en" {
+		if en, found := h.cards.Printing(card.OracleID, card.Set, card.CollectorNumber, "en"); found {
+			priced = en
+		}
+	}
+
+	since := time.Now().Add(-priceHistoryDays * 24 * time.Hour)
+	ru, err := h.history.ruSince(card.OracleID, since)
+	if err != nil {
+		h.reply(msg, "Could not load price history")
+		return
+	}
+	usd, err := h.history.usdSince(priced.ID, since)
+	if err != nil {
+		h.reply(msg, "Could not load price history")
+		return
+	}
+	if len(ru) == 0 && len(usd) == 0 {
+		h.reply(msg, fmt.Sprintf("No prices of %s have been observed yet, ask for them with [[$%s]]", card.LocalName, card.LocalName))
+		return
+	}
+
+	series := []chartSeries{}
+	legend := []string{}
+	if len(ru) > 0 {
+		min := chartSeries{color: chartMinColor}
+		avg := chartSeries{color: chartAvgColor}
+		for _, p := range ru {
+			min.points = append(min.points, chartPoint{at: p.At, value: float64(p.Min)})
+			avg.points = append(avg.points, chartPoint{at: p.At, value: float64(p.Avg)})
+		}
+		series = append(series, avg, min)
+		legend = append(legend, fmt.Sprintf("blue: min ₽ (now %d₽)", ru[len(ru)-1].Min), fmt.Sprintf("green: avg ₽ (now %d₽)", ru[len(ru)-1].Avg))
+	}
+	if len(usd) > 0 {
+		s := chartSeries{color: chartUSDColor, right: true}
+		for _, p := range usd {
+			s.points = append(s.points, chartPoint{at: p.At, value: p.USD})
+		}
+		series = append(series, s)
+		legend = append(legend, fmt.Sprintf("red: Scryfall $ for %s (now $%.2f)", strings.ToUpper(priced.Set), usd[len(usd)-1].USD))
+	}
+
+	var buf bytes.Buffer
+	if err := renderChart(&buf, series); err != nil {
+		log.WithFields(log.Fields{"cardID": card.ID, "err": err}).Error("cannot render price chart")
+		h.reply(msg, "Could not draw price history")
+		return
+	}
+	photo := tgbotapi.NewPhotoUpload(msg.Chat.ID, tgbotapi.FileBytes{Name: "pricehistory.png", Bytes: buf.Bytes()})
+	photo.Caption = fmt.Sprintf("%s, last %d days\n%s", card.LocalName, priceHistoryDays, strings.Join(legend, "\n"))
+	photo.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- photo
+}
+
+func (h *priceHistoryHandler) reply(msg tgbotapi.Message, text string) {
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func (h *priceHistoryHandler) Name() string {
+	return "Price History Handler"
+}
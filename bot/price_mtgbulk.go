@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ilyalavrinov/mtgbulkbuy/pkg/mtgbulk"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+)
+
+// mtgbulkProvider reports offers of russian stores and traders found by mtgbulk
+type mtgbulkProvider struct {
+	// skipTraders are covered by other providers
+	skipTraders map[string]bool
+	// process is mtgbulk.ProcessByNames, tests replace it
+	process func(req mtgbulk.NamesRequest) (*mtgbulk.NamesResult, error)
+}
+
+var _ BatchPriceProvider = &mtgbulkProvider{}
+
+func newMtgbulkProvider() *mtgbulkProvider {
+	return &mtgbulkProvider{
+		skipTraders: make(map[string]bool),
+		process:     mtgbulk.ProcessByNames,
+	}
+}
+
+func (p *mtgbulkProvider) Name() string {
+	return mtgbulkProviderName
+}
+
+// Key is the card name, stores do not distinguish printings
+func (p *mtgbulkProvider) Key(c carddb.Card) string {
+	return strings.ToLower(c.LocalName)
+}
+
+// Offers cannot be interrupted, mtgbulk does not support cancellation
+func (p *mtgbulkProvider) Offers(ctx context.Context, c carddb.Card) ([]Offer, error) {
+	found, err := p.BatchOffers(ctx, []CardRequest{{Card: c, Quantity: 1}})
+	offers, checked := found[p.Key(c)]
+	if !checked {
+		if err == nil {
+			err = fmt.Errorf("%q is not checked by mtgbulk", c.LocalName)
+		}
+		return nil, err
+	}
+	return offers, nil
+}
+
+// BatchOffers looks for every card in one mtgbulk request carrying the wanted quantities.
+// mtgbulk stops at the first card it does not know, the cards after it are left out of the result
+func (p *mtgbulkProvider) BatchOffers(ctx context.Context, cards []CardRequest) (map[string][]Offer, error) {
+	req := mtgbulk.NewNamesRequest()
+	names := make(map[string]string, len(cards)) // key -> requested name
+	for _, r := range cards {
+		key := p.Key(r.Card)
+		if _, found := names[key]; !found {
+			names[key] = r.Card.LocalName
+		}
+		qty := r.Quantity
+		if qty < 1 {
+			qty = 1
+		}
+		req.Cards[names[key]] += qty
+	}
+	res, err := p.process(req)
+	if res == nil {
+		return nil, err
+	}
+
+	offers := make(map[string][]Offer, len(names))
+	for key, name := range names {
+		found, checked := res.AllSortedCards[name]
+		if !checked {
+			continue
+		}
+		cardOffers := []Offer{}
+		for _, cp := range found.Prices {
+			if p.skipTraders[cp.Trader] {
+				continue
+			}
+			cardOffers = append(cardOffers, offerFromMtgbulk(cp))
+		}
+		offers[key] = cardOffers
+	}
+	return offers, err
+}
+
+func offerFromMtgbulk(cp mtgbulk.CardPrice) Offer {
+	currency := currencyRUB
+	if cp.Currency == mtgbulk.USD {
+		currency = currencyUSD
+	}
+	return Offer{
+		Source:   mtgbulkProviderName,
+		Seller:   cp.SellerFullName(),
+		Price:    float64(cp.Price),
+		Currency: currency,
+		Foil:     cp.Foil,
+		Quantity: cp.Quantity,
+		URL:      cp.URL,
+	}
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+)
+
+// PriceProvider fetches prices of a card from a single source
+type PriceProvider interface {
+	Name() string
+	// Key identifies what prices of the card depend on, cards with equal keys share cached prices
+	Key(c carddb.Card) string
+	Offers(ctx context.Context, c carddb.Card) ([]Offer, error)
+}
+
+// CardRequest is a card with the number of copies wanted
+type CardRequest struct {
+	Card     carddb.Card
+	Quantity int
+}
+
+// BatchPriceProvider can fetch offers of many cards in a single request
+type BatchPriceProvider interface {
+	PriceProvider
+	// BatchOffers returns offers by card key, cards missing in the result have not been checked
+	BatchOffers(ctx context.Context, cards []CardRequest) (map[string][]Offer, error)
+}
+
+// PriceProviderConfig is a section of the config describing a single provider
+type PriceProviderConfig struct {
+	Disabled       bool
+	TimeoutSeconds int
+	// Currency is a comma separated list of currencies kept from the provider, all of them if empty
+	Currency string
+	// URL overrides the address of the source for providers scraping a site
+	URL string
+}
+
+// priceSource is an enabled provider with its limits
+type priceSource struct {
+	provider   PriceProvider
+	timeout    time.Duration
+	currencies map[string]bool
+}
+
+func (s *priceSource) filter(offers []Offer) []Offer {
+	if len(s.currencies) == 0 {
+		return offers
+	}
+	kept := make([]Offer, 0, len(offers))
+	for _, o := range offers {
+		if s.currencies[o.Currency] {
+			kept = append(kept, o)
+		}
+	}
+	return kept
+}
+
+// PriceProviders is the registry of providers prices are requested from
+type PriceProviders struct {
+	sources []priceSource
+}
+
+const (
+	scryfallProviderName    = "scryfall"
+	mtgbulkProviderName     = "mtgbulk"
+	autumnMagicProviderName = "autumnmagic"
+)
+
+// defaultProviderTimeouts also lists every known provider, mtgbulk scrapes several stores one by one
+var defaultProviderTimeouts = map[string]time.Duration{
+	scryfallProviderName:    10 * time.Second,
+	mtgbulkProviderName:     2 * time.Minute,
+	autumnMagicProviderName: 30 * time.Second,
+}
+
+// NewPriceProviders creates every known provider which is not disabled in the config,
+// providers missing in the config are enabled with default settings
+func NewPriceProviders(cfg map[string]*PriceProviderConfig) (*PriceProviders, error) {
+	for name := range cfg {
+		if _, found := defaultProviderTimeouts[name]; !found {
+			return nil, fmt.Errorf("unknown price provider %q", name)
+		}
+	}
+	conf := func(name string) PriceProviderConfig {
+		if c, found := cfg[name]; found && c != nil {
+			return *c
+		}
+		return PriceProviderConfig{}
+	}
+
+	am := conf(autumnMagicProviderName)
+	bulk := newMtgbulkProvider()
+	if !am.Disabled {
+		// offers of the store are got directly, the ones from mtgbulk are less precise
+		bulk.skipTraders[autumnMagicTrader] = true
+	}
+	amURL := am.URL
+	if amURL == "" {
+		amURL = DefaultAutumnMagicURL
+	}
+	sfURL := conf(scryfallProviderName).URL
+	if sfURL == "" {
+		sfURL = carddb.DefaultAPIURL
+	}
+
+	all := []PriceProvider{
+		newScryfallProvider(sfURL),
+		bulk,
+		newAutumnMagic(amURL),
+	}
+	p := &PriceProviders{}
+	for _, provider := range all {
+		c := conf(provider.Name())
+		if c.Disabled {
+			continue
+		}
+		s := priceSource{
+			provider: provider,
+			timeout:  defaultProviderTimeouts[provider.Name()],
+		}
+		if c.TimeoutSeconds > 0 {
+			s.timeout = time.Duration(c.TimeoutSeconds) * time.Second
+		}
+		if c.Currency != "" {
+			s.currencies = make(map[string]bool)
+			for _, cur := range strings.Split(c.Currency, ",") {
+				s.currencies[strings.ToUpper(strings.TrimSpace(cur))] = true
+			}
+		}
+		p.sources = append(p.sources, s)
+	}
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+)
+
+// scryfallProvider reports prices Scryfall shows for a printing
+type scryfallProvider struct {
+	apiURL string
+}
+
+var _ PriceProvider = &scryfallProvider{}
+
+func newScryfallProvider(apiURL string) *scryfallProvider {
+	return &scryfallProvider{apiURL: strings.TrimRight(apiURL, "/")}
+}
+
+func (p *scryfallProvider) Name() string {
+	return scryfallProviderName
+}
+
+func (p *scryfallProvider) Key(c carddb.Card) string {
+	return c.ID
+}
+
+func (p *scryfallProvider) Offers(ctx context.Context, c carddb.Card) ([]Offer, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+"/cards/"+c.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected card response status: %s", resp.Status)
+	}
+
+	var full struct {
+		Prices struct {
+			USD     string
+			USDFoil string `json:"usd_foil"`
+			EUR     string
+			EURFoil string `json:"eur_foil"`
+			TIX     string
+		} `json:"prices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&full); err != nil {
+		return nil, err
+	}
+
+	offers := []Offer{}
+	for _, sp := range []struct {
+		value    string
+		currency string
+		foil     bool
+	}{
+		{full.Prices.USD, currencyUSD, false},
+		{full.Prices.USDFoil, currencyUSD, true},
+		{full.Prices.EUR, currencyEUR, false},
+		{full.Prices.EURFoil, currencyEUR, true},
+		{full.Prices.TIX, currencyTIX, false},
+	} {
+		v, err := strconv.ParseFloat(sp.value, 64)
+		if err != nil {
+			continue
+		}
+		offers = append(offers, Offer{
+			Source:   scryfallProviderName,
+			Seller:   "Scryfall",
+			Price:    v,
+			Currency: sp.currency,
+			Foil:     sp.foil,
+			URL:      c.ScryfallURI,
+		})
+	}
+	return offers, nil
+}
@@ -0,0 +1,388 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	priceWatchProperty = "priceWatches"
+
+	// maxWatchesPerUser keeps periodic checks cheap
+	maxWatchesPerUser = 20
+	// watchBatchSize is the number of cards whose prices are requested at the same time
+	watchBatchSize = 10
+)
+
+// priceWatch notifies a user in a chat once price of the card drops below the threshold
+type priceWatch struct {
+	CardID   string  `json:"card_id"`
+	Name     string  `json:"name"`
+	Chat     int64   `json:"chat"`
+	Below    float64 `json:"below"`
+	Currency string  `json:"currency"`
+	// Notified is set once the user has been notified, so the alert is repeated only after the price grows back
+	Notified bool `json:"notified"`
+}
+
+func (w *priceWatch) threshold() string {
+	return formatAmount(w.Below, w.Currency)
+}
+
+// current returns the cheapest non-foil offer in the watched currency, false if it is unknown.
+// Dollar prices are taken only from Scryfall as other dollar offers are listings of single traders
+func (w *priceWatch) current(prices CardPrices) (Offer, bool) {
+	for _, o := range withFoil(prices.InCurrency(w.Currency), false) {
+		if w.Currency == currencyUSD && o.Source != scryfallProviderName {
+			continue
+		}
+		return o, true
+	}
+	return Offer{}, false
+}
+
+type priceWatchHandler struct {
+	tgbotbase.BaseHandler
+
+	cron       tgbotbase.Cron
+	props      tgbotbase.PropertyStorage
+	cards      *carddb.CardDB
+	prices     *PriceCache
+	currencies *Currencies
+	period     time.Duration
+
+	// mu serializes modifications of stored watches between commands and checks
+	mu sync.Mutex
+}
+
+var _ tgbotbase.IncomingMessageHandler = &priceWatchHandler{}
+
+// NewPriceWatchHandler manages price watches of users, all of them are checked every period
+func NewPriceWatchHandler(cron tgbotbase.Cron,
+	props tgbotbase.PropertyStorage,
+	cards *carddb.CardDB,
+	prices *PriceCache,
+	currencies *Currencies,
+	period time.Duration) tgbotbase.IncomingMessageHandler {
+	return &priceWatchHandler{
+		cron:       cron,
+		props:      props,
+		cards:      cards,
+		prices:     prices,
+		currencies: currencies,
+		period:     period,
+	}
+}
+
+func (h *priceWatchHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	h.cron.AddJob(time.Now().Add(h.period), &priceWatchJob{h: h})
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *priceWatchHandler) trigger() (*regexp.Regexp, []string) {
+	return nil, []string{"watch", "watches", "unwatch"}
+}
+
+func (h *priceWatchHandler) HandleOne(msg tgbotapi.Message) {
+	if msg.From == nil {
+		return
+	}
+	var text string
+	switch msg.Command() {
+	case "watch":
+		text = h.handleWatch(msg)
+	case "watches":
+		text = h.handleWatches(msg)
+	case "unwatch":
+		text = h.handleUnwatch(msg)
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+var watchPriceRe = regexp.MustCompile(`^(\$)?(\d+(?:[.,]\d+)?)\s*(\$|usd|₽|р|руб|rub)?$`)
+
+// parseWatchPrice understands "5000", "5000₽", "$5.5" and "5usd"
+func parseWatchPrice(s string) (float64, string, bool) {
+	m := watchPriceRe.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, "", false
+	}
+	value, err := strconv.ParseFloat(strings.Replace(m[2], ",", ".", 1), 64)
+	if err != nil || value <= 0 {
+		return 0, "", false
+	}
+	currency := currencyRUB
+	if m[1] != "" || m[3] == "$" || m[3] == "usd" {
+		currency = currencyUSD
+	}
+	return value, currency, true
+}
+
+func (h *priceWatchHandler) handleWatch(msg tgbotapi.Message) string {
+	usage := "Usage: /watch <card> <price>, e.g. /watch Ragavan 5000 for russian stores or /watch Ragavan $50 for Scryfall USD"
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 2 {
+		return usage
+	}
+	below, currency, ok := parseWatchPrice(args[len(args)-1])
+	if !ok {
+		return usage
+	}
+	name := carddb.NormalizeName(strings.Join(args[:len(args)-1], " "))
+	card, candidates, found := h.cards.FindName(name, maxCandidates)
+	if !found {
+		if len(candidates) == 0 {
+			return fmt.Sprintf("I could not recognize card %q", name)
+		}
+		names := make([]string, 0, len(candidates))
+		for _, c := range candidates {
+			names = append(names, c.Card.LocalName)
+		}
+		return fmt.Sprintf("Several cards match %q: %s", name, strings.Join(names, ", "))
+	}
+
+	// Scryfall prices of localized printings are mostly missing
+	priced := card
+	if en, found := h.cards.ByOracleID(card.OracleID, "en"); found {
+		priced = en
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	user := tgbotbase.UserID(msg.From.ID)
+	watches, err := h.load(user)
+	if err != nil {
+		return "Could not load your watches"
+	}
+	w := priceWatch{CardID: priced.ID, Name: card.LocalName, Chat: msg.Chat.ID, Below: below, Currency: currency}
+	replaced := false
+	for i := range watches {
+		if watches[i].CardID == w.CardID && watches[i].Chat == w.Chat && watches[i].Currency == w.Currency {
+			watches[i] = w
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(watches) >= maxWatchesPerUser {
+			return fmt.Sprintf("You can have at most %d watches, remove some with /unwatch", maxWatchesPerUser)
+		}
+		watches = append(watches, w)
+	}
+	if err := h.save(user, watches); err != nil {
+		return "Could not save the watch"
+	}
+	return fmt.Sprintf("I will notify you when %s costs less than %s", w.Name, w.threshold())
+}
+
+func (h *priceWatchHandler) handleWatches(msg tgbotapi.Message) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	watches, err := h.load(tgbotbase.UserID(msg.From.ID))
+	if err != nil {
+		return "Could not load your watches"
+	}
+	lines := []string{}
+	for i, w := range watches {
+		if w.Chat == msg.Chat.ID {
+			lines = append(lines, fmt.Sprintf("%d. %s below %s", i+1, w.Name, w.threshold()))
+		}
+	}
+	if len(lines) == 0 {
+		return "You have no price watches in this chat, add one with /watch <card> <price>"
+	}
+	return "Your price watches:\n" + strings.Join(lines, "\n")
+}
+
+func (h *priceWatchHandler) handleUnwatch(msg tgbotapi.Message) string {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		return "Usage: /unwatch <number from /watches or card name>"
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	user := tgbotbase.UserID(msg.From.ID)
+	watches, err := h.load(user)
+	if err != nil {
+		return "Could not load your watches"
+	}
+	n, numErr := strconv.Atoi(arg)
+	name := carddb.NormalizeName(arg)
+	kept := make([]priceWatch, 0, len(watches))
+	removed := []string{}
+	for i, w := range watches {
+		match := w.Chat == msg.Chat.ID
+		if numErr == nil {
+			match = match && i+1 == n
+		} else {
+			match = match && strings.EqualFold(carddb.NormalizeName(w.Name), name)
+		}
+		if match {
+			removed = append(removed, fmt.Sprintf("%s below %s", w.Name, w.threshold()))
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	if len(removed) == 0 {
+		return fmt.Sprintf("No watch matches %q, see /watches", arg)
+	}
+	if err := h.save(user, kept); err != nil {
+		return "Could not remove the watch"
+	}
+	return "Removed: " + strings.Join(removed, ", ")
+}
+
+func (h *priceWatchHandler) load(user tgbotbase.UserID) ([]priceWatch, error) {
+	value, err := h.props.GetProperty(priceWatchProperty, user, tgbotbase.ChatID(user))
+	if err != nil {
+		log.WithFields(log.Fields{"user": user, "err": err}).Error("cannot get price watches")
+		return nil, err
+	}
+	return decodeWatches(value, user)
+}
+
+func decodeWatches(value string, user tgbotbase.UserID) ([]priceWatch, error) {
+	watches := []priceWatch{}
+	if value == "" {
+		return watches, nil
+	}
+	if err := json.Unmarshal([]byte(value), &watches); err != nil {
+		log.WithFields(log.Fields{"user": user, "err": err}).Error("cannot decode price watches")
+		return nil, err
+	}
+	return watches, nil
+}
+
+// save stores watches of the user, there is no way to delete a property, so an empty list is stored instead
+func (h *priceWatchHandler) save(user tgbotbase.UserID, watches []priceWatch) error {
+	b, err := json.Marshal(watches)
+	if err != nil {
+		return err
+	}
+	if err := h.props.SetPropertyForUser(priceWatchProperty, user, string(b)); err != nil {
+		log.WithFields(log.Fields{"user": user, "err": err}).Error("cannot save price watches")
+		return err
+	}
+	return nil
+}
+
+func (h *priceWatchHandler) Name() string {
+	return "Price Watch Handler"
+}
+
+type priceWatchJob struct {
+	h *priceWatchHandler
+}
+
+func (job *priceWatchJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	defer cron.AddJob(scheduledWhen.Add(job.h.period), job)
+
+	props, err := job.h.props.GetEveryHavingProperty(priceWatchProperty)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("cannot get price watches")
+		return
+	}
+	users := []tgbotbase.UserID{}
+	cardIDs := make(map[string]bool)
+	for _, p := range props {
+		watches, err := decodeWatches(p.Value, p.User)
+		if err != nil || len(watches) == 0 {
+			continue
+		}
+		users = append(users, p.User)
+		for _, w := range watches {
+			cardIDs[w.CardID] = true
+		}
+	}
+	prices := job.fetch(cardIDs)
+	log.WithFields(log.Fields{"users": len(users), "cards": len(cardIDs), "priced": len(prices)}).Info("checking price watches")
+
+	job.h.mu.Lock()
+	defer job.h.mu.Unlock()
+	for _, user := range users {
+		// watches are loaded again as they could have been changed while prices were fetched
+		watches, err := job.h.load(user)
+		if err != nil {
+			continue
+		}
+		changed := false
+		for i := range watches {
+			w := &watches[i]
+			p, found := prices[w.CardID]
+			if !found {
+				continue
+			}
+			cur, known := w.current(p)
+			if !known {
+				continue
+			}
+			if cur.Price >= w.Below {
+				changed = changed || w.Notified
+				w.Notified = false
+				continue
+			}
+			if w.Notified {
+				continue
+			}
+			job.notify(*w, cur)
+			w.Notified = true
+			changed = true
+		}
+		if changed {
+			job.h.save(user, watches)
+		}
+	}
+}
+
+// fetch gets prices of every watched card, at most watchBatchSize cards at once
+func (job *priceWatchJob) fetch(cardIDs map[string]bool) map[string]CardPrices {
+	var mu sync.Mutex
+	prices := make(map[string]CardPrices, len(cardIDs))
+	sem := make(chan struct{}, watchBatchSize)
+	var wg sync.WaitGroup
+	for id := range cardIDs {
+		card, found := job.h.cards.ByID(id)
+		if !found {
+			log.WithFields(log.Fields{"cardID": id}).Warn("watched card is not known anymore")
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c carddb.Card) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p, err := job.h.prices.Prices(c)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			prices[c.ID] = p
+			mu.Unlock()
+		}(card)
+	}
+	wg.Wait()
+	return prices
+}
+
+func (job *priceWatchJob) notify(w priceWatch, cur Offer) {
+	text := fmt.Sprintf("%s is now cheaper than %s:", escapeMarkdown(w.Name), escapeMarkdown(w.threshold()))
+	money := job.h.currencies.formatFor(job.h.props, 0, tgbotbase.ChatID(w.Chat))
+	text = fmt.Sprintf("%s\n%s", text, formatPrice("min", cur, money))
+	msg := tgbotapi.NewMessage(w.Chat, text)
+	msg.ParseMode = "MarkdownV2"
+	msg.DisableWebPagePreview = true
+	job.h.OutMsgCh <- msg
+}
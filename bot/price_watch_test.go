@@ -0,0 +1,35 @@
+package bot
+
+import "testing"
+
+func TestPriceWatchCurrent(t *testing.T) {
+	prices := CardPrices{Offers: []Offer{
+		{Source: mtgbulkProviderName, Seller: "trader", Price: 0.5, Currency: currencyUSD},
+		{Source: scryfallProviderName, Seller: "tcgplayer", Price: 1, Currency: currencyUSD, Foil: true},
+		{Source: scryfallProviderName, Seller: "tcgplayer", Price: 2, Currency: currencyUSD},
+		{Source: mtgbulkProviderName, Seller: "store", Price: 50, Currency: currencyRUB, Foil: true},
+		{Source: autumnMagicProviderName, Seller: "autumnsmagic", Price: 80, Currency: currencyRUB},
+		{Source: mtgbulkProviderName, Seller: "store", Price: 90, Currency: currencyRUB},
+	}}
+	cases := []struct {
+		currency string
+		want     float64
+		known    bool
+	}{
+		{currencyUSD, 2, true},
+		{currencyRUB, 80, true},
+		{currencyEUR, 0, false},
+	}
+	for _, tc := range cases {
+		w := priceWatch{Currency: tc.currency}
+		cur, known := w.current(prices)
+		if known != tc.known || cur.Price != tc.want {
+			t.Errorf("%s: got %v (known %v), want %v", tc.currency, cur.Price, known, tc.want)
+		}
+	}
+
+	w := priceWatch{Currency: currencyUSD}
+	if cur, known := w.current(CardPrices{Offers: prices.Offers[:2]}); known {
+		t.Errorf("foil or trader offer is watched: %+v", cur)
+	}
+}
@@ -0,0 +1,395 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchFetchConcurrency limits how many cards of a batch are requested at once from providers fetching card by card
+const batchFetchConcurrency = 8
+
+const (
+	currencyRUB = "RUB"
+	currencyUSD = "USD"
+	currencyEUR = "EUR"
+	currencyTIX = "TIX"
+)
+
+// Offer is a single price of a card reported by a provider
+type Offer struct {
+	Source   string  `json:"source"`
+	Seller   string  `json:"seller"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Foil     bool    `json:"foil,omitempty"`
+	// Quantity is the number of copies available, 0 if the source does not sell cards itself
+	Quantity int    `json:"quantity,omitempty"`
+	URL      string `json:"url"`
+}
+
+// CardPrices is everything known about prices of a card
+type CardPrices struct {
+	// Offers are sorted by price, prices in different currencies should not be compared
+	Offers []Offer
+	// Failed lists providers which have not answered in time
+	Failed []string
+}
+
+// InCurrency returns offers in the currency keeping them sorted by price
+func (p *CardPrices) InCurrency(currency string) []Offer {
+	offers := []Offer{}
+	for _, o := range p.Offers {
+		if o.Currency == currency {
+			offers = append(offers, o)
+		}
+	}
+	return offers
+}
+
+// Min returns the cheapest offer in the currency
+func (p *CardPrices) Min(currency string) (Offer, bool) {
+	for _, o := range p.Offers {
+		if o.Currency == currency {
+			return o, true
+		}
+	}
+	return Offer{}, false
+}
+
+// Avg returns average price of a single card among foil or non-foil offers in the currency
+func (p *CardPrices) Avg(currency string, foil bool) float64 {
+	offers := withFoil(p.InCurrency(currency), foil)
+	if len(offers) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, o := range offers {
+		total += o.Price
+	}
+	return total / float64(len(offers))
+}
+
+// withFoil returns either foil or non-foil offers keeping their order
+func withFoil(offers []Offer, foil bool) []Offer {
+	res := []Offer{}
+	for _, o := range offers {
+		if o.Foil == foil {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+type sellerOffers struct {
+	seller string
+	offers []Offer
+}
+
+// offersBySeller groups offers in the currency by seller keeping up to n cheapest offers of each seller,
+// sellers are ordered by their cheapest offer
+func (p *CardPrices) offersBySeller(currency string, n int) []sellerOffers {
+	bySeller := make(map[string]*sellerOffers)
+	res := []*sellerOffers{}
+	for _, o := range p.InCurrency(currency) {
+		so, found := bySeller[o.Seller]
+		if !found {
+			so = &sellerOffers{seller: o.Seller}
+			bySeller[o.Seller] = so
+			res = append(res, so)
+		}
+		if len(so.offers) < n {
+			so.offers = append(so.offers, o)
+		}
+	}
+
+	sellers := make([]sellerOffers, 0, len(res))
+	for _, so := range res {
+		sellers = append(sellers, *so)
+	}
+	return sellers
+}
+
+func sortOffers(offers []Offer) {
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Price < offers[j].Price
+	})
+}
+
+// formatAmount shows the price with its currency sign
+func formatAmount(price float64, currency string) string {
+	switch currency {
+	case currencyRUB:
+		return fmt.Sprintf("%d₽", int(price))
+	case currencyUSD:
+		return fmt.Sprintf("$%.2f", price)
+	case currencyEUR:
+		return fmt.Sprintf("€%.2f", price)
+	case currencyTIX:
+		return fmt.Sprintf("%.2f tix", price)
+	}
+	return fmt.Sprintf("%.2f %s", price, currency)
+}
+
+// Prices asks every provider for prices of the card at the same time, cached values are used when possible.
+// Providers not answering in time are skipped, error is returned only if none of them has answered
+func (c *PriceCache) Prices(card carddb.Card) (CardPrices, error) {
+	type result struct {
+		name   string
+		offers []Offer
+		err    error
+	}
+	sources := c.providers.sources
+	results := make(chan result, len(sources))
+	var obs observed
+	for _, s := range sources {
+		go func(s priceSource) {
+			var offers []Offer
+			err := c.get(s.provider.Name()+":"+s.provider.Key(card), &offers, func() (interface{}, error) {
+				offers, err := c.fetchWithDeadline(s, card)
+				if err == nil {
+					obs.mark(offers)
+				}
+				return offers, err
+			})
+			results <- result{name: s.provider.Name(), offers: offers, err: err}
+		}(s)
+	}
+
+	var prices CardPrices
+	for range sources {
+		r := <-results
+		if r.err != nil {
+			log.WithFields(log.Fields{"provider": r.name, "cardID": card.ID, "err": r.err}).Error("cannot get card prices")
+			prices.Failed = append(prices.Failed, r.name)
+			continue
+		}
+		prices.Offers = append(prices.Offers, r.offers...)
+	}
+	sortOffers(prices.Offers)
+	if len(sources) > 0 && len(prices.Failed) == len(sources) {
+		return prices, errors.New("no price provider has answered")
+	}
+	c.record(card, prices, &obs)
+	return prices, nil
+}
+
+// record adds prices fetched from providers rather than from cache to the history
+func (c *PriceCache) record(card carddb.Card, prices CardPrices, obs *observed) {
+	now := time.Now()
+	if obs.has(currencyUSD) {
+		for _, o := range prices.InCurrency(currencyUSD) {
+			if o.Source == scryfallProviderName && !o.Foil {
+				c.history.recordUSD(card.ID, o.Price, now)
+				break
+			}
+		}
+	}
+	if obs.has(currencyRUB) {
+		if rub := withFoil(prices.InCurrency(currencyRUB), false); len(rub) > 0 {
+			c.history.recordRU(card.OracleID, int(rub[0].Price), int(prices.Avg(currencyRUB, false)), now)
+		}
+	}
+}
+
+// BatchPrices gets prices of many cards at once, e.g. of a decklist. Fresh cached prices are used as they are,
+// cards missing in the cache are requested from providers supporting batches in a single request,
+// other providers are asked card by card at most batchFetchConcurrency cards at once.
+// Prices are keyed by card id, cards which no provider has answered for are left out
+func (c *PriceCache) BatchPrices(cards []CardRequest) map[string]CardPrices {
+	sources := c.providers.sources
+	all := make([]CardPrices, len(cards))
+	obs := make([]observed, len(cards))
+	var mu sync.Mutex
+	add := func(i int, name string, offers []Offer, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			log.WithFields(log.Fields{"provider": name, "cardID": cards[i].Card.ID, "err": err}).Error("cannot get card prices")
+			all[i].Failed = append(all[i].Failed, name)
+			return
+		}
+		all[i].Offers = append(all[i].Offers, offers...)
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Add(1)
+		go func(s priceSource) {
+			defer wg.Done()
+			if bp, ok := s.provider.(BatchPriceProvider); ok {
+				c.batchFromSource(s, bp, cards, obs, add)
+			} else {
+				c.eachFromSource(s, cards, obs, add)
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	res := make(map[string]CardPrices, len(cards))
+	for i, r := range cards {
+		prices := all[i]
+		if len(sources) > 0 && len(prices.Failed) == len(sources) {
+			continue
+		}
+		sortOffers(prices.Offers)
+		c.record(r.Card, prices, &obs[i])
+		res[r.Card.ID] = prices
+	}
+	return res
+}
+
+// batchFromSource asks the provider for every card missing in the cache in one request
+func (c *PriceCache) batchFromSource(s priceSource, bp BatchPriceProvider, cards []CardRequest, obs []observed, add func(int, string, []Offer, error)) {
+	name := s.provider.Name()
+	misses := []CardRequest{}
+	missed := make(map[string][]int) // provider key -> card positions
+	for i, r := range cards {
+		key := s.provider.Key(r.Card)
+		var offers []Offer
+		if c.fresh(name+":"+key, &offers) {
+			add(i, name, offers, nil)
+			continue
+		}
+		if _, found := missed[key]; !found {
+			misses = append(misses, r)
+		}
+		missed[key] = append(missed[key], i)
+	}
+	if len(misses) == 0 {
+		return
+	}
+
+	found, err := c.fetchBatchWithDeadline(s, bp, misses)
+	if err != nil {
+		log.WithFields(log.Fields{"provider": name, "cards": len(misses), "found": len(found), "err": err}).Warn("batch prices are incomplete")
+	}
+	for key, positions := range missed {
+		offers, checked := found[key]
+		for _, i := range positions {
+			if !checked {
+				add(i, name, nil, fmt.Errorf("%s has not checked the card", name))
+				continue
+			}
+			obs[i].mark(offers)
+			add(i, name, offers, nil)
+		}
+	}
+}
+
+// eachFromSource asks the provider for every card separately, cached prices are used as in Prices
+func (c *PriceCache) eachFromSource(s priceSource, cards []CardRequest, obs []observed, add func(int, string, []Offer, error)) {
+	sem := make(chan struct{}, batchFetchConcurrency)
+	var wg sync.WaitGroup
+	for i := range cards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			card := cards[i].Card
+			var offers []Offer
+			err := c.get(s.provider.Name()+":"+s.provider.Key(card), &offers, func() (interface{}, error) {
+				offers, err := c.fetchWithDeadline(s, card)
+				if err == nil {
+					obs[i].mark(offers)
+				}
+				return offers, err
+			})
+			add(i, s.provider.Name(), offers, err)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// fetchWithDeadline stops waiting for the provider once its timeout is over.
+// Late answers are still cached as most of providers cannot be interrupted
+func (c *PriceCache) fetchWithDeadline(s priceSource, card carddb.Card) ([]Offer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	type answer struct {
+		offers []Offer
+		err    error
+	}
+	done := make(chan answer, 1)
+	go func() {
+		offers, err := s.provider.Offers(ctx, card)
+		done <- answer{offers: s.filter(offers), err: err}
+	}()
+
+	select {
+	case a := <-done:
+		return a.offers, a.err
+	case <-ctx.Done():
+		key := s.provider.Name() + ":" + s.provider.Key(card)
+		go func() {
+			if a := <-done; a.err == nil {
+				log.WithFields(log.Fields{"key": key}).Info("late prices are cached")
+				c.save(key, a.offers)
+			}
+		}()
+		return nil, fmt.Errorf("%s has not answered in %s", s.provider.Name(), s.timeout)
+	}
+}
+
+// fetchBatchWithDeadline gives the provider its timeout for every card, cards of a batch are looked for one by one.
+// Offers of every card found are cached, late answers included
+func (c *PriceCache) fetchBatchWithDeadline(s priceSource, bp BatchPriceProvider, cards []CardRequest) (map[string][]Offer, error) {
+	timeout := s.timeout * time.Duration(len(cards))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type answer struct {
+		offers map[string][]Offer
+		err    error
+	}
+	done := make(chan answer, 1)
+	go func() {
+		found, err := bp.BatchOffers(ctx, cards)
+		offers := make(map[string][]Offer, len(found))
+		for key, o := range found {
+			offers[key] = s.filter(o)
+			if _, err := c.save(s.provider.Name()+":"+key, offers[key]); err != nil {
+				log.WithFields(log.Fields{"provider": s.provider.Name(), "key": key, "err": err}).Error("cannot cache batch prices")
+			}
+		}
+		done <- answer{offers: offers, err: err}
+	}()
+
+	select {
+	case a := <-done:
+		return a.offers, a.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s has not answered in %s", s.provider.Name(), timeout)
+	}
+}
+
+// observed remembers currencies of prices fetched from providers rather than from cache
+type observed struct {
+	mu         sync.Mutex
+	currencies map[string]bool
+}
+
+func (o *observed) mark(offers []Offer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.currencies == nil {
+		o.currencies = make(map[string]bool)
+	}
+	for _, of := range offers {
+		o.currencies[of.Currency] = true
+	}
+}
+
+func (o *observed) has(currency string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.currencies[currency]
+}
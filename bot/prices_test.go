@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+)
+
+// fakeProvider sells every card it knows for a price in roubles and remembers what it was asked for
+type fakeProvider struct {
+	name   string
+	prices map[string]float64 // key -> price
+
+	mu      sync.Mutex
+	singles []string
+	batches []string
+}
+
+func (p *fakeProvider) Name() string             { return p.name }
+func (p *fakeProvider) Key(c carddb.Card) string { return strings.ToLower(c.Name) }
+
+func (p *fakeProvider) offer(key string, qty int) ([]Offer, bool) {
+	price, found := p.prices[key]
+	if !found {
+		return nil, false
+	}
+	return []Offer{{Source: p.name, Seller: p.name, Price: price, Currency: currencyRUB, Quantity: qty}}, true
+}
+
+func (p *fakeProvider) Offers(ctx context.Context, c carddb.Card) ([]Offer, error) {
+	p.mu.Lock()
+	p.singles = append(p.singles, p.Key(c))
+	p.mu.Unlock()
+	offers, found := p.offer(p.Key(c), 1)
+	if !found {
+		return nil, errors.New("unknown card")
+	}
+	return offers, nil
+}
+
+func (p *fakeProvider) asked() (singles, batches []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	singles = append([]string{}, p.singles...)
+	sort.Strings(singles)
+	return singles, append([]string{}, p.batches...)
+}
+
+// fakeBatchProvider answers whole batches, unknown cards are left out as mtgbulk does
+type fakeBatchProvider struct {
+	fakeProvider
+}
+
+func (p *fakeBatchProvider) BatchOffers(ctx context.Context, cards []CardRequest) (map[string][]Offer, error) {
+	asked := make([]string, 0, len(cards))
+	res := make(map[string][]Offer, len(cards))
+	for _, r := range cards {
+		key := p.Key(r.Card)
+		asked = append(asked, fmt.Sprintf("%s x%d", key, r.Quantity))
+		if offers, found := p.offer(key, r.Quantity); found {
+			res[key] = offers
+		}
+	}
+	sort.Strings(asked)
+	p.mu.Lock()
+	p.batches = append(p.batches, strings.Join(asked, ", "))
+	p.mu.Unlock()
+	return res, nil
+}
+
+func testPriceCache(providers ...PriceProvider) *PriceCache {
+	p := &PriceProviders{}
+	for _, provider := range providers {
+		p.sources = append(p.sources, priceSource{provider: provider, timeout: time.Second})
+	}
+	return NewPriceCache(PriceCacheConfig{TTL: time.Hour}, nil, p, nil)
+}
+
+func priceCard(name string) carddb.Card {
+	return carddb.Card{ID: strings.ToLower(name), Name: name, LocalName: name}
+}
+
+func checkAsked(t *testing.T, p *fakeProvider, wantSingles, wantBatches []string) {
+	t.Helper()
+	singles, batches := p.asked()
+	if strings.Join(singles, "; ") != strings.Join(wantSingles, "; ") {
+		t.Errorf("%s is asked for %q, want %q", p.name, singles, wantSingles)
+	}
+	if strings.Join(batches, "; ") != strings.Join(wantBatches, "; ") {
+		t.Errorf("%s gets batches %q, want %q", p.name, batches, wantBatches)
+	}
+}
+
+func TestBatchPricesUseCache(t *testing.T) {
+	known := map[string]float64{"lightning bolt": 30, "counterspell": 50, "lightning helix": 70}
+	batch := &fakeBatchProvider{fakeProvider{name: "batch", prices: known}}
+	single := &fakeProvider{name: "single", prices: known}
+	cache := testPriceCache(batch, single)
+
+	got := cache.BatchPrices([]CardRequest{
+		{Card: priceCard("Lightning Bolt"), Quantity: 4},
+		{Card: priceCard("Counterspell"), Quantity: 2},
+		{Card: priceCard("Nope")},
+	})
+	checkAsked(t, &batch.fakeProvider, nil, []string{"counterspell x2, lightning bolt x4, nope x0"})
+	checkAsked(t, single, []string{"counterspell", "lightning bolt", "nope"}, nil)
+	if len(got) != 2 {
+		t.Fatalf("got prices of %d cards %+v, want 2", len(got), got)
+	}
+	bolt := got["lightning bolt"]
+	if len(bolt.Offers) != 2 || bolt.Offers[0].Quantity != 4 || len(bolt.Failed) != 0 {
+		t.Errorf("unexpected bolt prices %+v", bolt)
+	}
+	if _, found := got["nope"]; found {
+		t.Error("card which no provider knows is priced")
+	}
+
+	// only the new card is requested
+	got = cache.BatchPrices([]CardRequest{
+		{Card: priceCard("Lightning Bolt"), Quantity: 1},
+		{Card: priceCard("Lightning Helix"), Quantity: 3},
+	})
+	checkAsked(t, &batch.fakeProvider, nil, []string{"counterspell x2, lightning bolt x4, nope x0", "lightning helix x3"})
+	checkAsked(t, single, []string{"counterspell", "lightning bolt", "lightning helix", "nope"}, nil)
+	if len(got) != 2 || len(got["lightning helix"].Offers) != 2 {
+		t.Errorf("unexpected prices %+v", got)
+	}
+
+	// batches fill the cache of single card requests
+	if _, err := cache.Prices(priceCard("Lightning Helix")); err != nil {
+		t.Fatal(err)
+	}
+	checkAsked(t, &batch.fakeProvider, nil, []string{"counterspell x2, lightning bolt x4, nope x0", "lightning helix x3"})
+	checkAsked(t, single, []string{"counterspell", "lightning bolt", "lightning helix", "nope"}, nil)
+}
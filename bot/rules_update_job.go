@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/ilyalavrinov/tgbot-mtg/comprules"
+	log "github.com/sirupsen/logrus"
+)
+
+type rulesUpdateJob struct {
+	rules  *comprules.RulesDB
+	period time.Duration
+}
+
+// NewRulesUpdateJob creates a job which periodically checks for newly published comprehensive rules
+func NewRulesUpdateJob(rules *comprules.RulesDB, period time.Duration) tgbotbase.CronJob {
+	return &rulesUpdateJob{
+		rules:  rules,
+		period: period,
+	}
+}
+
+func (job *rulesUpdateJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	defer cron.AddJob(scheduledWhen.Add(job.period), job)
+
+	updated, err := job.rules.Update()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Unable to update comprehensive rules")
+		return
+	}
+	log.WithFields(log.Fields{"updated": updated}).Info("comprehensive rules update check done")
+}
@@ -0,0 +1,405 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	mtgsaleFeed = "mtgsale"
+	edhrecFeed  = "edhrec"
+
+	mtgsaleDealNotifyProperty     = "mtgsaleDealNotify"
+	edhrecCmdrDailyNotifyProperty = "edhrecCmdrDailyNotify"
+	// dailyDigestProperty keeps the schedule of the digest of the chat, empty if feeds are delivered one by one
+	dailyDigestProperty = "dailyDigest"
+
+	// defaultTimezone is used when a chat has not mentioned its own one, most of the users are from Moscow
+	defaultTimezone = "Europe/Moscow"
+)
+
+// dailyFeed is a daily notification chats can subscribe to
+type dailyFeed struct {
+	name        string
+	property    string
+	description string
+}
+
+var dailyFeeds = []dailyFeed{
+	{name: mtgsaleFeed, property: mtgsaleDealNotifyProperty, description: "card of the day at mtgsale.ru"},
+	{name: edhrecFeed, property: edhrecCmdrDailyNotifyProperty, description: "commander of the day at EDHREC"},
+}
+
+func findFeed(name string) (dailyFeed, bool) {
+	for _, f := range dailyFeeds {
+		if f.name == strings.ToLower(name) {
+			return f, true
+		}
+	}
+	return dailyFeed{}, false
+}
+
+func feedNames() string {
+	names := make([]string, 0, len(dailyFeeds))
+	for _, f := range dailyFeeds {
+		names = append(names, f.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// subscription is the value of a feed property of a subscribed chat. Updates published outside of
+// the delivery window are kept until it opens, From equal to To means there is no window.
+// Subscriptions made before windows were introduced have plain non-empty values and no window
+type subscription struct {
+	From int    `json:"from"` // minutes since local midnight
+	To   int    `json:"to"`
+	TZ   string `json:"tz"`
+}
+
+func parseSubscription(value string) subscription {
+	var s subscription
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		return subscription{}
+	}
+	return s
+}
+
+func (s subscription) location() *time.Location {
+	return loadTimezone(s.TZ)
+}
+
+func loadTimezone(tz string) *time.Location {
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.WithFields(log.Fields{"tz": tz, "err": err}).Error("cannot load timezone")
+		return time.Local
+	}
+	return loc
+}
+
+// next returns the moment an update published now should be delivered at
+func (s subscription) next(now time.Time) time.Time {
+	if s.From == s.To {
+		return now
+	}
+	local := now.In(s.location())
+	minute := local.Hour()*60 + local.Minute()
+	inside := s.From <= minute && minute < s.To
+	if s.From > s.To {
+		// the window passes midnight
+		inside = minute >= s.From || minute < s.To
+	}
+	if inside {
+		return now
+	}
+	return atMinute(local, s.From)
+}
+
+func (s subscription) String() string {
+	if s.From == s.To {
+		return "as soon as published"
+	}
+	tz := s.TZ
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	return fmt.Sprintf("from %s to %s %s", formatMinute(s.From), formatMinute(s.To), tz)
+}
+
+// digestSchedule is the time of the day all updates of the chat are delivered at in a single message
+type digestSchedule struct {
+	At int    `json:"at"` // minutes since local midnight
+	TZ string `json:"tz"`
+}
+
+func (d digestSchedule) next(now time.Time) time.Time {
+	return atMinute(now.In(loadTimezone(d.TZ)), d.At)
+}
+
+func (d digestSchedule) String() string {
+	tz := d.TZ
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	return fmt.Sprintf("at %s %s", formatMinute(d.At), tz)
+}
+
+// chatDigest returns the digest schedule of the chat, false if the chat gets updates one by one
+func chatDigest(props tgbotbase.PropertyStorage, chat tgbotbase.ChatID) (digestSchedule, bool) {
+	value, err := props.GetProperty(dailyDigestProperty, 0, chat)
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat, "err": err}).Error("cannot get digest schedule")
+		return digestSchedule{}, false
+	}
+	if value == "" {
+		return digestSchedule{}, false
+	}
+	var d digestSchedule
+	if err := json.Unmarshal([]byte(value), &d); err != nil {
+		log.WithFields(log.Fields{"chat": chat, "value": value, "err": err}).Error("cannot parse digest schedule")
+		return digestSchedule{}, false
+	}
+	return d, true
+}
+
+// atMinute returns the nearest moment not earlier than now at the given minute of the local day
+func atMinute(local time.Time, minute int) time.Time {
+	t := time.Date(local.Year(), local.Month(), local.Day(), minute/60, minute%60, 0, 0, local.Location())
+	if t.Before(local) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func formatMinute(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+var minuteRe = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?$`)
+
+// parseMinute understands "9", "09:30" and "21:00"
+func parseMinute(s string) (int, error) {
+	m := minuteRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a time of the day", s)
+	}
+	h, _ := strconv.Atoi(m[1])
+	min := 0
+	if m[2] != "" {
+		min, _ = strconv.Atoi(m[2])
+	}
+	if h > 24 || min > 59 || (h == 24 && min > 0) {
+		return 0, fmt.Errorf("%q is not a time of the day", s)
+	}
+	return (h*60 + min) % (24 * 60), nil
+}
+
+// parseTimezone checks that the timezone is known, empty one stands for the default
+func parseTimezone(tz string) (string, error) {
+	if tz == "" {
+		return "", nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return "", fmt.Errorf("unknown timezone %q, use names like Europe/Moscow", tz)
+	}
+	return tz, nil
+}
+
+// subscriber is a chat subscribed to a feed
+type subscriber struct {
+	chat tgbotbase.ChatID
+	sub  subscription
+}
+
+// subscribers returns chats subscribed to the feed at the moment.
+// Properties set for a user in someone else's chat are ignored as they do not make the chat subscribed
+func subscribers(props tgbotbase.PropertyStorage, property string) []subscriber {
+	values, err := props.GetEveryHavingProperty(property)
+	if err != nil {
+		log.WithFields(log.Fields{"property": property, "err": err}).Error("cannot get subscribed chats")
+		return nil
+	}
+	seen := make(map[tgbotbase.ChatID]bool, len(values))
+	subs := make([]subscriber, 0, len(values))
+	for _, v := range values {
+		if v.Value == "" || seen[v.Chat] {
+			continue
+		}
+		if (v.User != 0) && (tgbotbase.ChatID(v.User) != v.Chat) {
+			continue
+		}
+		seen[v.Chat] = true
+		subs = append(subs, subscriber{chat: v.Chat, sub: parseSubscription(v.Value)})
+	}
+	return subs
+}
+
+func chatSubscription(props tgbotbase.PropertyStorage, property string, chat tgbotbase.ChatID) (subscription, bool) {
+	for _, s := range subscribers(props, property) {
+		if s.chat == chat {
+			return s.sub, true
+		}
+	}
+	return subscription{}, false
+}
+
+type subscriptionHandler struct {
+	tgbotbase.BaseHandler
+
+	props tgbotbase.PropertyStorage
+}
+
+var _ tgbotbase.IncomingMessageHandler = &subscriptionHandler{}
+
+// NewSubscriptionHandler manages subscriptions of chats to daily feeds
+func NewSubscriptionHandler(props tgbotbase.PropertyStorage) tgbotbase.IncomingMessageHandler {
+	return &subscriptionHandler{props: props}
+}
+
+func (h *subscriptionHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(h.trigger())
+}
+
+func (h *subscriptionHandler) trigger() (*regexp.Regexp, []string) {
+	return nil, []string{"subscribe", "unsubscribe", "subscriptions", "digest"}
+}
+
+func (h *subscriptionHandler) HandleOne(msg tgbotapi.Message) {
+	chat := tgbotbase.ChatID(msg.Chat.ID)
+	args := strings.Fields(msg.CommandArguments())
+	var text string
+	switch msg.Command() {
+	case "subscribe":
+		text = h.handleSubscribe(chat, args)
+	case "unsubscribe":
+		text = h.handleUnsubscribe(chat, args)
+	case "subscriptions":
+		text = h.handleSubscriptions(chat)
+	case "digest":
+		text = h.handleDigest(chat, args)
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+// handleSubscribe understands "/subscribe <feed> [HH:MM-HH:MM] [timezone]", subscribing again changes the window
+func (h *subscriptionHandler) handleSubscribe(chat tgbotbase.ChatID, args []string) string {
+	usage := fmt.Sprintf("Use /subscribe <feed> [09:00-22:00] [Europe/Moscow], available feeds: %s", feedNames())
+	if len(args) == 0 || len(args) > 3 {
+		return usage
+	}
+	f, found := findFeed(args[0])
+	if !found {
+		return usage
+	}
+
+	var sub subscription
+	if len(args) > 1 {
+		bounds := strings.Split(args[1], "-")
+		if len(bounds) != 2 {
+			return usage
+		}
+		var err error
+		if sub.From, err = parseMinute(bounds[0]); err != nil {
+			return err.Error()
+		}
+		if sub.To, err = parseMinute(bounds[1]); err != nil {
+			return err.Error()
+		}
+	}
+	if len(args) > 2 {
+		var err error
+		if sub.TZ, err = parseTimezone(args[2]); err != nil {
+			return err.Error()
+		}
+	}
+
+	_, wasSubscribed := chatSubscription(h.props, f.property, chat)
+	value, _ := json.Marshal(sub)
+	if err := h.props.SetPropertyForChat(f.property, chat, string(value)); err != nil {
+		log.WithFields(log.Fields{"chat": chat, "feed": f.name, "err": err}).Error("cannot subscribe")
+		return "Could not subscribe, please try again later"
+	}
+	if wasSubscribed {
+		return fmt.Sprintf("The chat will get %s %s", f.description, sub)
+	}
+	return fmt.Sprintf("The chat is subscribed to %s, it is delivered %s", f.description, sub)
+}
+
+func (h *subscriptionHandler) handleUnsubscribe(chat tgbotbase.ChatID, args []string) string {
+	if len(args) != 1 {
+		return fmt.Sprintf("Use /unsubscribe <feed>, available feeds: %s", feedNames())
+	}
+	f, found := findFeed(args[0])
+	if !found {
+		return fmt.Sprintf("Use /unsubscribe <feed>, available feeds: %s", feedNames())
+	}
+	values, err := h.props.GetEveryHavingProperty(f.property)
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat, "feed": f.name, "err": err}).Error("cannot get subscriptions")
+		return "Could not unsubscribe, please try again later"
+	}
+	// subscriptions made by hand might have been set for a user in a private chat
+	for _, v := range values {
+		if v.Chat != chat || v.Value == "" {
+			continue
+		}
+		if err := h.props.SetPropertyForUserInChat(f.property, v.User, v.Chat, ""); err != nil {
+			log.WithFields(log.Fields{"chat": chat, "feed": f.name, "err": err}).Error("cannot unsubscribe")
+			return "Could not unsubscribe, please try again later"
+		}
+	}
+	return fmt.Sprintf("The chat is unsubscribed from %s", f.description)
+}
+
+func (h *subscriptionHandler) handleSubscriptions(chat tgbotbase.ChatID) string {
+	lines := []string{}
+	for _, f := range dailyFeeds {
+		if sub, found := chatSubscription(h.props, f.property, chat); found {
+			lines = append(lines, fmt.Sprintf("%s: %s, %s", f.name, f.description, sub))
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("The chat has no subscriptions, use /subscribe <feed>, available feeds: %s", feedNames())
+	}
+	text := "The chat is subscribed to:\n" + strings.Join(lines, "\n")
+	if d, found := chatDigest(h.props, chat); found {
+		text = fmt.Sprintf("%s\nAll of them are delivered in a single digest %s", text, d)
+	}
+	return text
+}
+
+// handleDigest understands "/digest HH:MM [timezone]" and "/digest off"
+func (h *subscriptionHandler) handleDigest(chat tgbotbase.ChatID, args []string) string {
+	usage := "Use /digest 09:00 [Europe/Moscow] to get all feeds in a single message every day or /digest off"
+	if len(args) == 0 {
+		if d, found := chatDigest(h.props, chat); found {
+			return fmt.Sprintf("The digest is delivered %s\n%s", d, usage)
+		}
+		return usage
+	}
+	if len(args) > 2 {
+		return usage
+	}
+
+	value := ""
+	var d digestSchedule
+	if strings.ToLower(args[0]) != "off" {
+		var err error
+		if d.At, err = parseMinute(args[0]); err != nil {
+			return err.Error()
+		}
+		if len(args) > 1 {
+			if d.TZ, err = parseTimezone(args[1]); err != nil {
+				return err.Error()
+			}
+		}
+		b, _ := json.Marshal(d)
+		value = string(b)
+	}
+	if err := h.props.SetPropertyForChat(dailyDigestProperty, chat, value); err != nil {
+		log.WithFields(log.Fields{"chat": chat, "err": err}).Error("cannot set digest schedule")
+		return "Could not change the digest, please try again later"
+	}
+	if value == "" {
+		return "Feeds will be delivered one by one"
+	}
+	return fmt.Sprintf("All feeds will be delivered in a single digest %s", d)
+}
+
+func (h *subscriptionHandler) Name() string {
+	return "subscriptions"
+}
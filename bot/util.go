@@ -6,9 +6,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
 )
 
-var markdownToEscape = []string{"\\", "`", "*", "_", "{", "}", "[", "]", "(", ")", "#", "+", "-", ".", "!"}
+var markdownToEscape = []string{"\\", "`", "*", "_", "{", "}", "[", "]", "(", ")", "#", "+", "-", ".", "!", "~", ">", "=", "|"}
 
 func escapeMarkdown(s string) string {
 	for _, e := range markdownToEscape {
@@ -39,7 +42,76 @@ func loadPicToTmp(url, prefix string) (string, error) {
 	return tmp.Name(), nil
 }
 
-func formatPrice(prefix string, p price) string {
-	seller := escapeMarkdown(p.Seller)
-	return fmt.Sprintf("%s %d₽ at [%s](%s)", prefix, p.Price, seller, p.URL)
+func formatPrice(prefix string, o Offer, money moneyFormat) string {
+	seller := escapeMarkdown(o.Seller)
+	return fmt.Sprintf("%s %s at [%s](%s)", prefix, escapeMarkdown(money.amount(o.Price, o.Currency)), seller, o.URL)
+}
+
+// formatCardInfo describes a card with its mana cost, type line and a link to Scryfall
+func formatCardInfo(c carddb.Card) string {
+	info := escapeMarkdown(c.FullTypeLine())
+	if cost := c.FullManaCost(); cost != "" {
+		info = fmt.Sprintf("%s %s", escapeMarkdown(cost), info)
+	}
+	return fmt.Sprintf("%s [Scryfall](%s)", info, c.ScryfallURI)
+}
+
+// maxMessageLen is the limit of a single Telegram message text
+const maxMessageLen = 4096
+
+// splitMessage joins lines into as few texts as possible, none of them exceeding Telegram limits.
+// Lines which do not fit into a single message are split first
+func splitMessage(lines []string) []string {
+	parts := make([]string, 0, len(lines))
+	for _, l := range lines {
+		parts = append(parts, splitLine(l)...)
+	}
+
+	texts := []string{}
+	cur := ""
+	for _, l := range parts {
+		if cur != "" && len(cur)+1+len(l) > maxMessageLen {
+			texts = append(texts, cur)
+			cur = ""
+		}
+		if cur == "" {
+			cur = l
+		} else {
+			cur = cur + "\n" + l
+		}
+	}
+	if cur != "" {
+		texts = append(texts, cur)
+	}
+	return texts
+}
+
+// splitLine cuts a line longer than maxMessageLen at whitespaces where possible,
+// otherwise between characters keeping an escaping backslash with the character it escapes
+func splitLine(l string) []string {
+	parts := []string{}
+	for len(l) > maxMessageLen {
+		if cut := strings.LastIndexAny(l[:maxMessageLen+1], " \n\t"); cut > 0 {
+			parts = append(parts, l[:cut])
+			l = l[cut+1:]
+			continue
+		}
+		cut := maxMessageLen
+		for cut > 0 && !utf8.RuneStart(l[cut]) {
+			cut--
+		}
+		slashes := 0
+		for slashes < cut && l[cut-1-slashes] == '\\' {
+			slashes++
+		}
+		if slashes%2 == 1 {
+			cut--
+		}
+		if cut == 0 {
+			cut = maxMessageLen
+		}
+		parts = append(parts, l[:cut])
+		l = l[cut:]
+	}
+	return append(parts, l)
 }
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func checkTexts(t *testing.T, texts []string, want string) {
+	t.Helper()
+	for i, text := range texts {
+		if len(text) > maxMessageLen {
+			t.Errorf("text %d has %d bytes", i, len(text))
+		}
+		if !utf8.ValidString(text) {
+			t.Errorf("text %d is cut inside a character", i)
+		}
+	}
+	if got := strings.Join(texts, ""); got != want {
+		t.Errorf("texts do not add up to the original, got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestSplitMessageJoinsLines(t *testing.T) {
+	line := strings.Repeat("a", 1000)
+	texts := splitMessage([]string{line, line, line, line, line})
+	if len(texts) != 2 || texts[0] != strings.Repeat(line+"\n", 3)+line || texts[1] != line {
+		t.Errorf("unexpected texts of %d bytes", len(texts))
+	}
+	if texts := splitMessage(nil); len(texts) != 0 {
+		t.Errorf("empty message is split into %q", texts)
+	}
+}
+
+func TestSplitMessageLongLineAtWhitespace(t *testing.T) {
+	words := make([]string, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		words = append(words, "ruling")
+	}
+	line := strings.Join(words, " ")
+	texts := splitMessage([]string{"Rulings:", line})
+	for i, text := range texts {
+		if len(text) > maxMessageLen {
+			t.Errorf("text %d has %d bytes", i, len(text))
+		}
+		for _, w := range strings.Fields(text) {
+			if w != "ruling" && w != "Rulings:" {
+				t.Errorf("text %d has a word cut into %q", i, w)
+			}
+		}
+	}
+	if got := strings.Count(strings.Join(texts, " "), "ruling"); got != len(words) {
+		t.Errorf("got %d words, want %d", got, len(words))
+	}
+}
+
+func TestSplitMessageLongWord(t *testing.T) {
+	// 3 bytes long runes are not aligned with the limit
+	word := strings.Repeat("ж", 3000)
+	checkTexts(t, splitMessage([]string{word}), word)
+
+	// escaped characters stay with their backslash
+	escaped := strings.Repeat("a", maxMessageLen-1) + `\.` + strings.Repeat(`\\`, 10)
+	texts := splitMessage([]string{escaped})
+	checkTexts(t, texts, escaped)
+	for i, text := range texts[1:] {
+		if !strings.HasPrefix(text, `\.`) {
+			t.Errorf("text %d starts with %q", i+1, text[:2])
+		}
+	}
+	escaped = strings.Repeat("a", maxMessageLen-2) + `\\` + `\.`
+	checkTexts(t, splitMessage([]string{escaped}), escaped)
+	if texts := splitMessage([]string{escaped}); texts[0][len(texts[0])-2:] != `\\` {
+		t.Errorf("escaped backslash is split: %q", texts[0][len(texts[0])-2:])
+	}
+}
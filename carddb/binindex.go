@@ -0,0 +1,185 @@
+package carddb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// binIndexVersion must be increased whenever Card layout changes
+const binIndexVersion = 2
+
+type binIndexHeader struct {
+	Version  int
+	DumpHash string
+	Count    int
+}
+
+func hashFile(fpath string) (string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readBinIndex loads cards saved by writeBinIndex if they were built from the dump with the same hash
+func readBinIndex(idxPath, dumpHash string) (*index, error) {
+	started := time.Now()
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReaderSize(f, 1<<20))
+	var header binIndexHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("cannot decode index header: %w", err)
+	}
+	if header.Version != binIndexVersion || header.DumpHash != dumpHash {
+		return nil, fmt.Errorf("index is outdated: version %d, dump hash %s", header.Version, header.DumpHash)
+	}
+
+	idx := newIndex()
+	for i := 0; i < header.Count; i++ {
+		var c Card
+		if err := dec.Decode(&c); err != nil {
+			return nil, fmt.Errorf("cannot decode card #%d: %w", i, err)
+		}
+		idx.add(c)
+	}
+	idx.finish()
+	log.WithFields(log.Fields{"idxPath": idxPath, "cards": header.Count, "took": time.Since(started)}).Info("binary index loaded")
+	return idx, nil
+}
+
+// writeBinIndex saves every card of the index, the file is replaced only once it is completely written
+func writeBinIndex(idxPath, dumpHash string, idx *index) error {
+	started := time.Now()
+	tmpPath := idxPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	// keeping dump order makes name preferences identical to decoding of the dump itself
+	ids := idx.ids
+	w := bufio.NewWriterSize(f, 1<<20)
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(binIndexHeader{Version: binIndexVersion, DumpHash: dumpHash, Count: len(ids)}); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := enc.Encode(idx.byID[id]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, idxPath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"idxPath": idxPath, "cards": len(ids), "took": time.Since(started)}).Info("binary index written")
+	return nil
+}
+
+// stringPool makes equal strings share memory, most of card fields are repeated among printings
+type stringPool struct {
+	strs       map[string]string
+	legalities map[string]map[string]string
+}
+
+func newStringPool() *stringPool {
+	return &stringPool{
+		strs:       make(map[string]string),
+		legalities: make(map[string]map[string]string),
+	}
+}
+
+func (p *stringPool) intern(s string) string {
+	if s == "" {
+		return s
+	}
+	if is, found := p.strs[s]; found {
+		return is
+	}
+	p.strs[s] = s
+	return s
+}
+
+func (p *stringPool) internSlice(ss []string) []string {
+	for i := range ss {
+		ss[i] = p.intern(ss[i])
+	}
+	return ss
+}
+
+func (p *stringPool) internLegalities(l map[string]string) map[string]string {
+	if len(l) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(l))
+	for k, v := range l {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	key := strings.Join(keys, ",")
+	if il, found := p.legalities[key]; found {
+		return il
+	}
+	il := make(map[string]string, len(l))
+	for k, v := range l {
+		il[p.intern(k)] = p.intern(v)
+	}
+	p.legalities[key] = il
+	return il
+}
+
+func (p *stringPool) internCard(c Card) Card {
+	c.OracleID = p.intern(c.OracleID)
+	c.Name = p.intern(c.Name)
+	c.LocalName = p.intern(c.LocalName)
+	c.Lang = p.intern(c.Lang)
+	c.TypeLine = p.intern(c.TypeLine)
+	c.ManaCost = p.intern(c.ManaCost)
+	c.OracleText = p.intern(c.OracleText)
+	c.Power = p.intern(c.Power)
+	c.Toughness = p.intern(c.Toughness)
+	c.Set = p.intern(c.Set)
+	c.SetName = p.intern(c.SetName)
+	c.Rarity = p.intern(c.Rarity)
+	c.Colors = p.internSlice(c.Colors)
+	c.ColorIdentity = p.internSlice(c.ColorIdentity)
+	c.Legalities = p.internLegalities(c.Legalities)
+	for i := range c.CardFaces {
+		f := &c.CardFaces[i]
+		f.Name = p.intern(f.Name)
+		f.ManaCost = p.intern(f.ManaCost)
+		f.TypeLine = p.intern(f.TypeLine)
+		f.OracleText = p.intern(f.OracleText)
+		f.Colors = p.internSlice(f.Colors)
+	}
+	return c
+}
@@ -0,0 +1,144 @@
+package carddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+)
+
+// benchCards is about the number of printings in all_cards
+const benchCards = 50000
+
+var benchFormats = []string{"standard", "future", "historic", "pioneer", "modern", "legacy", "pauper",
+	"vintage", "penny", "commander", "brawl", "duel", "oldschool", "premodern"}
+
+// writeBenchDump makes a dump looking like the Scryfall one: printings of the same cards in several languages
+func writeBenchDump(dumpPath string) error {
+	f, err := os.Create(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	langs := []string{"en", "en", "en", "ru", "de", "ja"}
+	legalities := make(map[string]string, len(benchFormats))
+	for _, l := range benchFormats {
+		legalities[l] = "legal"
+	}
+	enc := json.NewEncoder(f)
+	f.WriteString("[")
+	for i := 0; i < benchCards; i++ {
+		if i > 0 {
+			f.WriteString(",")
+		}
+		oracle := i / len(langs)
+		c := Card{
+			ID:              fmt.Sprintf("%08x-0000-0000-0000-%012x", i, i),
+			OracleID:        fmt.Sprintf("%08x-1111-1111-1111-%012x", oracle, oracle),
+			Name:            fmt.Sprintf("Benchmark Card %d", oracle),
+			Lang:            langs[i%len(langs)],
+			ImageURIs:       Images{Small: fmt.Sprintf("https://c1.scryfall.com/file/scryfall-cards/small/front/%d.jpg", i), Normal: fmt.Sprintf("https://c1.scryfall.com/file/scryfall-cards/normal/front/%d.jpg", i)},
+			URI:             fmt.Sprintf("https://api.scryfall.com/cards/%d", i),
+			RulingsURI:      fmt.Sprintf("https://api.scryfall.com/cards/%d/rulings", i),
+			ScryfallURI:     fmt.Sprintf("https://scryfall.com/card/set%d/%d", oracle%300, i),
+			CollectorNumber: fmt.Sprint(oracle % 400),
+			TypeLine:        "Creature — Human Wizard",
+			ManaCost:        "{2}{U}{U}",
+			CMC:             4,
+			Colors:          []string{"U"},
+			ColorIdentity:   []string{"U"},
+			OracleText:      fmt.Sprintf("Flying\nWhen Benchmark Card %d enters the battlefield, draw a card.", oracle),
+			Power:           "2",
+			Toughness:       "3",
+			Set:             fmt.Sprintf("s%02d", oracle%300),
+			SetName:         fmt.Sprintf("Benchmark Set %d", oracle%300),
+			Rarity:          "rare",
+			Legalities:      legalities,
+		}
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString("]")
+	return err
+}
+
+// benchIndexFiles prepares a dump and the binary index made of it
+func benchIndexFiles(b *testing.B) (dir, dumpPath, idxPath, hash string) {
+	dir, err := ioutil.TempDir("", "carddb-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	dumpPath = path.Join(dir, dumpFilename)
+	idxPath = path.Join(dir, binIndexFilename)
+	if err := writeBenchDump(dumpPath); err != nil {
+		b.Fatal(err)
+	}
+	if hash, err = hashFile(dumpPath); err != nil {
+		b.Fatal(err)
+	}
+	idx, err := decodeDump(dumpPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := writeBinIndex(idxPath, hash, idx); err != nil {
+		b.Fatal(err)
+	}
+	return dir, dumpPath, idxPath, hash
+}
+
+// reportHeap reports memory the loaded index keeps after garbage is collected
+func reportHeap(b *testing.B, load func() *index) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	idx := load()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(idx)
+	b.ReportMetric(float64(after.HeapInuse-before.HeapInuse)/(1<<20), "heap-MB")
+}
+
+func BenchmarkDecodeDump(b *testing.B) {
+	dir, dumpPath, _, _ := benchIndexFiles(b)
+	defer os.RemoveAll(dir)
+	load := func() *index {
+		idx, err := decodeDump(dumpPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return idx
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		load()
+	}
+	b.StopTimer()
+	reportHeap(b, load)
+}
+
+func BenchmarkReadBinIndex(b *testing.B) {
+	dir, _, idxPath, hash := benchIndexFiles(b)
+	defer os.RemoveAll(dir)
+	load := func() *index {
+		idx, err := readBinIndex(idxPath, hash)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return idx
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		load()
+	}
+	b.StopTimer()
+	reportHeap(b, load)
+}
@@ -0,0 +1,124 @@
+package carddb
+
+import "strings"
+
+type Images struct {
+	Small  string `json:"small"`
+	Normal string `json:"normal"`
+}
+type CardFace struct {
+	Name       string   `json:"name"`
+	ManaCost   string   `json:"mana_cost"`
+	TypeLine   string   `json:"type_line"`
+	OracleText string   `json:"oracle_text"`
+	Colors     []string `json:"colors"`
+	ImageURIs  Images   `json:"image_uris"`
+}
+type Card struct {
+	ID              string `json:"id"`
+	OracleID        string `json:"oracle_id"`
+	Name            string `json:"name"`
+	LocalName       string `json:"printed_name"`
+	Lang            string `json:"lang"`
+	ImageURIs       Images `json:"image_uris"`
+	URI             string `json:"uri"`
+	RulingsURI      string `json:"rulings_uri"`
+	ScryfallURI     string `json:"scryfall_uri"`
+	CollectorNumber string `json:"collector_number"`
+
+	TypeLine      string            `json:"type_line"`
+	ManaCost      string            `json:"mana_cost"`
+	CMC           float64           `json:"cmc"`
+	Colors        []string          `json:"colors"`
+	ColorIdentity []string          `json:"color_identity"`
+	OracleText    string            `json:"oracle_text"`
+	Power         string            `json:"power"`
+	Toughness     string            `json:"toughness"`
+	Set           string            `json:"set"`
+	SetName       string            `json:"set_name"`
+	Rarity        string            `json:"rarity"`
+	Legalities    map[string]string `json:"legalities"`
+	CardFaces     []CardFace        `json:"card_faces"`
+}
+
+// ImageURL returns a picture of the card, for multi-faced cards the front face is used
+func (c *Card) ImageURL() string {
+	if c.ImageURIs.Normal != "" || len(c.CardFaces) == 0 {
+		return c.ImageURIs.Normal
+	}
+	return c.CardFaces[0].ImageURIs.Normal
+}
+
+// ThumbURL returns a small picture of the card
+func (c *Card) ThumbURL() string {
+	if c.ImageURIs.Small != "" || len(c.CardFaces) == 0 {
+		return c.ImageURIs.Small
+	}
+	return c.CardFaces[0].ImageURIs.Small
+}
+
+// FullTypeLine returns type line of the card including all of its faces
+func (c *Card) FullTypeLine() string {
+	if c.TypeLine != "" || len(c.CardFaces) == 0 {
+		return c.TypeLine
+	}
+	lines := make([]string, 0, len(c.CardFaces))
+	for _, f := range c.CardFaces {
+		lines = append(lines, f.TypeLine)
+	}
+	return strings.Join(lines, " // ")
+}
+
+// FullOracleText returns oracle text of the card including all of its faces
+func (c *Card) FullOracleText() string {
+	if c.OracleText != "" || len(c.CardFaces) == 0 {
+		return c.OracleText
+	}
+	texts := make([]string, 0, len(c.CardFaces))
+	for _, f := range c.CardFaces {
+		texts = append(texts, f.OracleText)
+	}
+	return strings.Join(texts, "\n//\n")
+}
+
+// FullManaCost returns mana cost of the card including all of its faces
+func (c *Card) FullManaCost() string {
+	if c.ManaCost != "" || len(c.CardFaces) == 0 {
+		return c.ManaCost
+	}
+	costs := make([]string, 0, len(c.CardFaces))
+	for _, f := range c.CardFaces {
+		if f.ManaCost != "" {
+			costs = append(costs, f.ManaCost)
+		}
+	}
+	return strings.Join(costs, " // ")
+}
+
+// AllColors returns colors of the card, for multi-faced cards colors of every face are merged
+func (c *Card) AllColors() []string {
+	if len(c.Colors) != 0 || len(c.CardFaces) == 0 {
+		return c.Colors
+	}
+	seen := make(map[string]bool, 5)
+	colors := []string{}
+	for _, f := range c.CardFaces {
+		for _, col := range f.Colors {
+			if !seen[col] {
+				seen[col] = true
+				colors = append(colors, col)
+			}
+		}
+	}
+	return colors
+}
+
+// names returns every name the card can be requested by
+func (c *Card) names() []string {
+	names := []string{c.Name, c.LocalName}
+	if strings.Contains(c.Name, "//") {
+		names = append(names, strings.Split(c.Name, " // ")...)
+		names = append(names, strings.Split(c.LocalName, " // ")...)
+	}
+	return names
+}
@@ -0,0 +1,419 @@
+package carddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dumpFilename     = "all.dump.json"
+	dumpMetaFilename = "all.dump.meta.json"
+	binIndexFilename = "all.dump.idx"
+)
+
+// CardDB keeps cards from Scryfall dump and provides lookups over them.
+// Index is immutable once built, so refresh swaps the whole index while lookups keep using the old one
+type CardDB struct {
+	cfg Config
+
+	mu  sync.RWMutex
+	idx *index
+
+	updateMu sync.Mutex
+}
+
+type index struct {
+	ids         []string // in order of the dump
+	byID        map[string]Card
+	byName      map[string]Card
+	byOracleID  map[string][]string // oracle id -> ids of all printings
+	bySetNumber map[string][]string // set/number -> ids of the printing in all languages
+	names       *nameIndex
+	searchable  []Card
+
+	pool *stringPool // used only while the index is being built
+}
+
+// Config describes where cards are stored and which Scryfall bulk file is used
+type Config struct {
+	Dir      string
+	APIURL   string // Scryfall API, might be replaced with a local server
+	BulkType string // oracle_cards, default_cards, all_cards
+}
+
+func New(cfg Config) *CardDB {
+	if cfg.APIURL == "" {
+		cfg.APIURL = DefaultAPIURL
+	}
+	if cfg.BulkType == "" {
+		cfg.BulkType = DefaultBulkType
+	}
+	return &CardDB{
+		cfg: cfg,
+		idx: newIndex(),
+	}
+}
+
+func newIndex() *index {
+	return &index{
+		byID:        make(map[string]Card),
+		byName:      make(map[string]Card),
+		byOracleID:  make(map[string][]string),
+		bySetNumber: make(map[string][]string),
+		names:       newNameIndex(map[string]Card{}),
+		searchable:  make([]Card, 0),
+		pool:        newStringPool(),
+	}
+}
+
+func setNumberKey(set, number string) string {
+	return strings.ToLower(set) + "/" + strings.ToLower(number)
+}
+
+// Load reads the dump from the disk, downloading it first if it is absent
+func (db *CardDB) Load() error {
+	dumpPath := path.Join(db.cfg.Dir, dumpFilename)
+	if err := os.MkdirAll(db.cfg.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dumpPath); os.IsNotExist(err) {
+		log.WithFields(log.Fields{"dumpPath": dumpPath}).Info("dump is absent, loading")
+		bulk, err := fetchBulkData(db.cfg.APIURL, db.cfg.BulkType)
+		if err != nil {
+			return fmt.Errorf("cannot get bulk data info: %w", err)
+		}
+		if err := db.download(bulk); err != nil {
+			return err
+		}
+	}
+
+	idx, err := db.loadIndex(dumpPath)
+	if err != nil {
+		return err
+	}
+	db.swap(idx)
+	return nil
+}
+
+// loadIndex reads the binary index if it matches the dump, otherwise decodes the dump and saves the index for next starts
+func (db *CardDB) loadIndex(dumpPath string) (*index, error) {
+	metaPath := path.Join(db.cfg.Dir, dumpMetaFilename)
+	meta, err := readDumpMeta(metaPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Warn("cannot read dump meta")
+	}
+	if meta.SHA256 == "" {
+		log.WithFields(log.Fields{"dumpPath": dumpPath}).Info("dump hash is unknown, calculating")
+		if meta.SHA256, err = hashFile(dumpPath); err != nil {
+			return nil, err
+		}
+		if err := writeDumpMeta(metaPath, meta); err != nil {
+			log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Error("cannot write dump meta")
+		}
+	}
+
+	idxPath := path.Join(db.cfg.Dir, binIndexFilename)
+	idx, err := readBinIndex(idxPath, meta.SHA256)
+	if err == nil {
+		return idx, nil
+	}
+	log.WithFields(log.Fields{"idxPath": idxPath, "err": err}).Info("binary index cannot be used, decoding dump")
+
+	idx, err = decodeDump(dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBinIndex(idxPath, meta.SHA256, idx); err != nil {
+		log.WithFields(log.Fields{"idxPath": idxPath, "err": err}).Error("cannot write binary index")
+	}
+	return idx, nil
+}
+
+// Update checks Scryfall for a newer dump and, if there is one, downloads it and rebuilds the index.
+// Lookups are served from the previous index until the new one is ready
+func (db *CardDB) Update() (bool, error) {
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+
+	bulk, err := fetchBulkData(db.cfg.APIURL, db.cfg.BulkType)
+	if err != nil {
+		return false, fmt.Errorf("cannot get bulk data info: %w", err)
+	}
+
+	metaPath := path.Join(db.cfg.Dir, dumpMetaFilename)
+	meta, err := readDumpMeta(metaPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Warn("cannot read dump meta, dump will be reloaded")
+	}
+	if meta.Type == bulk.Type && !bulk.UpdatedAt.After(meta.UpdatedAt) {
+		log.WithFields(log.Fields{"updatedAt": meta.UpdatedAt}).Debug("dump is up to date")
+		return false, nil
+	}
+
+	log.WithFields(log.Fields{"current": meta.UpdatedAt, "new": bulk.UpdatedAt, "type": bulk.Type}).Info("newer dump is available")
+	dumpPath := path.Join(db.cfg.Dir, dumpFilename)
+	dumpTmp := dumpPath + ".tmp"
+	defer os.Remove(dumpTmp)
+	if err := downloadDump(bulk, dumpTmp); err != nil {
+		return false, err
+	}
+	idx, err := decodeDump(dumpTmp)
+	if err != nil {
+		return false, err
+	}
+	hash, err := db.install(dumpTmp, bulk)
+	if err != nil {
+		return false, err
+	}
+	idxPath := path.Join(db.cfg.Dir, binIndexFilename)
+	if err := writeBinIndex(idxPath, hash, idx); err != nil {
+		log.WithFields(log.Fields{"idxPath": idxPath, "err": err}).Error("cannot write binary index")
+	}
+	db.swap(idx)
+	log.WithFields(log.Fields{"updatedAt": bulk.UpdatedAt}).Info("card index has been updated")
+	return true, nil
+}
+
+func (db *CardDB) download(bulk bulkData) error {
+	dumpTmp := path.Join(db.cfg.Dir, dumpFilename) + ".tmp"
+	defer os.Remove(dumpTmp)
+	if err := downloadDump(bulk, dumpTmp); err != nil {
+		return err
+	}
+	_, err := db.install(dumpTmp, bulk)
+	return err
+}
+
+// install puts the downloaded dump in place of the current one and remembers its origin and hash
+func (db *CardDB) install(dumpTmp string, bulk bulkData) (string, error) {
+	hash, err := hashFile(dumpTmp)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(dumpTmp, path.Join(db.cfg.Dir, dumpFilename)); err != nil {
+		return "", err
+	}
+	metaPath := path.Join(db.cfg.Dir, dumpMetaFilename)
+	if err := writeDumpMeta(metaPath, dumpMeta{Type: bulk.Type, UpdatedAt: bulk.UpdatedAt, SHA256: hash}); err != nil {
+		log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Error("cannot write dump meta")
+	}
+	return hash, nil
+}
+
+func (db *CardDB) current() *index {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.idx
+}
+
+func (db *CardDB) swap(idx *index) {
+	db.mu.Lock()
+	db.idx = idx
+	db.mu.Unlock()
+}
+
+func decodeDump(dumpPath string) (*index, error) {
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	started := time.Now()
+	log.WithFields(log.Fields{"dumpPath": dumpPath}).Info("decoding dump")
+	dec := json.NewDecoder(f)
+	if _, err = dec.Token(); err != nil {
+		return nil, fmt.Errorf("cannot tokenize dump: %w", err)
+	}
+	idx := newIndex()
+	for dec.More() {
+		var c Card
+		if err := dec.Decode(&c); err != nil {
+			return nil, fmt.Errorf("cannot decode dump: %w", err)
+		}
+		idx.add(c)
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, fmt.Errorf("cannot advance to next token at dump: %w", err)
+	}
+	idx.finish()
+	log.WithFields(log.Fields{
+		"took":        time.Since(started),
+		"cardsByID":   len(idx.byID),
+		"cardsByName": len(idx.byName),
+		"oracleIDs":   len(idx.byOracleID),
+		"trigrams":    len(idx.names.trigrams)}).Info("decoding done")
+	return idx, nil
+}
+
+func (idx *index) add(c Card) {
+	if c.LocalName == "" {
+		c.LocalName = c.Name
+	}
+	c = idx.pool.internCard(c)
+	idx.ids = append(idx.ids, c.ID)
+	idx.byID[c.ID] = c
+	if c.OracleID != "" {
+		idx.byOracleID[c.OracleID] = append(idx.byOracleID[c.OracleID], c.ID)
+	}
+	if c.Set != "" && c.CollectorNumber != "" {
+		key := setNumberKey(c.Set, c.CollectorNumber)
+		idx.bySetNumber[key] = append(idx.bySetNumber[key], c.ID)
+	}
+	if c.Lang == "en" {
+		idx.searchable = append(idx.searchable, c)
+	}
+	for _, n := range c.names() {
+		n := NormalizeName(n)
+		_, found := idx.byName[n]
+		if found {
+			if c.Lang == "en" {
+				idx.byName[n] = c
+			}
+		} else {
+			idx.byName[n] = c
+		}
+	}
+}
+
+// finish builds derived structures once all cards are added
+func (idx *index) finish() {
+	idx.names = newNameIndex(idx.byName)
+	idx.pool = nil
+}
+
+// pickLang chooses a printing in the requested language falling back to english and then to any
+func (idx *index) pickLang(ids []string, lang string) (Card, bool) {
+	var fallback *Card
+	for _, id := range ids {
+		c := idx.byID[id]
+		if c.Lang == lang {
+			return c, true
+		}
+		if fallback == nil || (c.Lang == "en" && fallback.Lang != "en") {
+			fallback = &c
+		}
+	}
+	if fallback == nil {
+		return Card{}, false
+	}
+	return *fallback, true
+}
+
+// ByID returns a printing by its Scryfall ID
+func (db *CardDB) ByID(id string) (Card, bool) {
+	c, found := db.current().byID[id]
+	return c, found
+}
+
+// ByName returns a card by its exact name in any language
+func (db *CardDB) ByName(name string) (Card, bool) {
+	c, found := db.current().byName[NormalizeName(name)]
+	return c, found
+}
+
+// ByOracleID returns a printing of the card in the requested language if there is one
+func (db *CardDB) ByOracleID(oracleID, lang string) (Card, bool) {
+	idx := db.current()
+	return idx.pickLang(idx.byOracleID[oracleID], lang)
+}
+
+// Printings returns every printing of the card in every language
+func (db *CardDB) Printings(oracleID string) []Card {
+	idx := db.current()
+	ids := idx.byOracleID[oracleID]
+	res := make([]Card, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, idx.byID[id])
+	}
+	return res
+}
+
+// BySetNumber returns a printing by its set code and collector number
+func (db *CardDB) BySetNumber(set, number, lang string) (Card, bool) {
+	idx := db.current()
+	return idx.pickLang(idx.bySetNumber[setNumberKey(set, number)], lang)
+}
+
+// Printing returns the printing of the card from the set, collector number is optional
+func (db *CardDB) Printing(oracleID, set, number, lang string) (Card, bool) {
+	idx := db.current()
+	ids := []string{}
+	for _, id := range idx.byOracleID[oracleID] {
+		c := idx.byID[id]
+		if !strings.EqualFold(c.Set, set) {
+			continue
+		}
+		if number != "" && !strings.EqualFold(c.CollectorNumber, number) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return idx.pickLang(ids, lang)
+}
+
+// InLanguage returns the same printing in another language, or any printing in this language if there is no such.
+// Cards having no printings in the language are returned in English, and as they are if there is no English one either
+func (db *CardDB) InLanguage(c Card, lang string) Card {
+	idx := db.current()
+	for _, l := range []string{lang, "en"} {
+		if c.Lang == l {
+			return c
+		}
+		if other, found := idx.pickLang(idx.bySetNumber[setNumberKey(c.Set, c.CollectorNumber)], l); found && other.Lang == l {
+			return other
+		}
+		if other, found := idx.pickLang(idx.byOracleID[c.OracleID], l); found && other.Lang == l {
+			return other
+		}
+	}
+	return c
+}
+
+// FindName resolves exact, partial or misspelled name. If the name is ambiguous found is false
+// and candidates contain the best guesses
+func (db *CardDB) FindName(query string, limit int) (card Card, candidates []NameMatch, found bool) {
+	return db.current().names.find(query, limit)
+}
+
+// SuggestNames returns cards which names start with, contain or resemble the query
+func (db *CardDB) SuggestNames(query string, limit int) []NameMatch {
+	q := NormalizeName(query)
+	idx := db.current()
+	if c, found := idx.byName[q]; found {
+		return []NameMatch{{Name: q, Card: c}}
+	}
+	return idx.names.search(q, limit)
+}
+
+// Search evaluates a query against every english printing, one result per card name
+func (db *CardDB) Search(query string) ([]Card, error) {
+	filter, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	res := []Card{}
+	searchable := db.current().searchable
+	for i := range searchable {
+		c := &searchable[i]
+		if found[c.Name] || !filter(c) {
+			continue
+		}
+		found[c.Name] = true
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
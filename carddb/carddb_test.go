@@ -0,0 +1,45 @@
+package carddb
+
+import "testing"
+
+func testDB(cards ...Card) *CardDB {
+	db := New(Config{})
+	idx := newIndex()
+	for _, c := range cards {
+		idx.add(c)
+	}
+	idx.finish()
+	db.swap(idx)
+	return db
+}
+
+func TestInLanguage(t *testing.T) {
+	db := testDB(
+		Card{ID: "bolt-en", OracleID: "bolt", Name: "Lightning Bolt", Lang: "en", Set: "m10", CollectorNumber: "146"},
+		Card{ID: "bolt-ru", OracleID: "bolt", Name: "Lightning Bolt", Lang: "ru", Set: "m10", CollectorNumber: "146"},
+		Card{ID: "bolt-de-4ed", OracleID: "bolt", Name: "Lightning Bolt", Lang: "de", Set: "4ed", CollectorNumber: "208"},
+		Card{ID: "bolt-en-4ed", OracleID: "bolt", Name: "Lightning Bolt", Lang: "en", Set: "4ed", CollectorNumber: "208"},
+		Card{ID: "promo-ja", OracleID: "promo", Name: "Japanese Promo", Lang: "ja", Set: "pjp", CollectorNumber: "1"},
+	)
+	cases := []struct {
+		from, lang, want string
+	}{
+		{"bolt-en", "ru", "bolt-ru"},
+		{"bolt-ru", "ru", "bolt-ru"},
+		// the same printing is preferred
+		{"bolt-en-4ed", "de", "bolt-de-4ed"},
+		// any printing in the language if the same one does not exist
+		{"bolt-en-4ed", "ru", "bolt-ru"},
+		// English if there is nothing in the language
+		{"bolt-ru", "ja", "bolt-en"},
+		{"bolt-de-4ed", "fr", "bolt-en-4ed"},
+		// as is if there is no English printing either
+		{"promo-ja", "ru", "promo-ja"},
+	}
+	for _, tc := range cases {
+		c, _ := db.ByID(tc.from)
+		if got := db.InLanguage(c, tc.lang); got.ID != tc.want {
+			t.Errorf("%s in %s: got %s, want %s", tc.from, tc.lang, got.ID, tc.want)
+		}
+	}
+}
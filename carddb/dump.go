@@ -0,0 +1,228 @@
+package carddb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultAPIURL   = "https://api.scryfall.com"
+	DefaultBulkType = "all_cards"
+
+	downloadAttempts = 5
+)
+
+// retryDelay is multiplied by the attempt number between download attempts
+var retryDelay = 10 * time.Second
+
+// bulkData describes a single bulk file published by Scryfall
+type bulkData struct {
+	Type        string    `json:"type"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	DownloadURI string    `json:"download_uri"`
+	Size        int64     `json:"size"`
+}
+
+// dumpMeta is stored next to the dump to know which bulk file it was taken from
+type dumpMeta struct {
+	Type      string    `json:"type"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SHA256    string    `json:"sha256"`
+}
+
+func fetchBulkData(apiURL, kind string) (bulkData, error) {
+	url := strings.TrimRight(apiURL, "/") + "/bulk-data"
+	resp, err := http.Get(url)
+	if err != nil {
+		return bulkData{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return bulkData{}, fmt.Errorf("unexpected bulk data response status: %s", resp.Status)
+	}
+
+	var list struct {
+		Data []bulkData `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return bulkData{}, err
+	}
+	for _, d := range list.Data {
+		if d.Type == kind {
+			return d, nil
+		}
+	}
+	return bulkData{}, fmt.Errorf("no bulk data of type %q", kind)
+}
+
+func readDumpMeta(metaPath string) (dumpMeta, error) {
+	var meta dumpMeta
+	b, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(b, &meta)
+	return meta, err
+}
+
+func writeDumpMeta(metaPath string, meta dumpMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, b, 0644)
+}
+
+// downloadDump fetches the bulk file into dumpPath. Transfer is retried and resumed from
+// the partially downloaded file, which is kept until the download is complete. A complete file
+// which cannot be unpacked is removed, otherwise the next attempt would resume it and get it again
+func downloadDump(bulk bulkData, dumpPath string) error {
+	partPath := fmt.Sprintf("%s.%d.part", dumpPath, bulk.UpdatedAt.Unix())
+	removeStaleParts(dumpPath, partPath)
+
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		err = downloadPart(bulk.DownloadURI, partPath)
+		if err == nil {
+			break
+		}
+		log.WithFields(log.Fields{"url": bulk.DownloadURI, "attempt": attempt, "err": err}).Warn("dump download failed")
+		if attempt < downloadAttempts {
+			time.Sleep(time.Duration(attempt) * retryDelay)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := unpackDump(partPath, dumpPath); err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	if bulk.Size > 0 {
+		st, err := os.Stat(dumpPath)
+		if err != nil {
+			return err
+		}
+		if st.Size() != bulk.Size {
+			os.Remove(dumpPath)
+			os.Remove(partPath)
+			return fmt.Errorf("dump size mismatch: expected %d, got %d", bulk.Size, st.Size())
+		}
+	}
+	os.Remove(partPath)
+	log.WithFields(log.Fields{"dumpFile": dumpPath, "updatedAt": bulk.UpdatedAt}).Info("new dump has been downloaded")
+	return nil
+}
+
+// removeStaleParts cleans partial downloads of previous bulk files
+func removeStaleParts(dumpPath, keep string) {
+	parts, _ := filepath.Glob(dumpPath + ".*.part")
+	for _, p := range parts {
+		if p != keep {
+			log.WithFields(log.Fields{"path": p}).Info("removing stale partial dump")
+			os.Remove(p)
+		}
+	}
+}
+
+// downloadPart appends missing bytes to the partial file. Body is stored as it was transferred,
+// so resuming works with gzip transfer encoding as well
+func downloadPart(url, partPath string) error {
+	var offset int64
+	if st, err := os.Stat(partPath); err == nil {
+		offset = st.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	log.WithFields(log.Fields{"url": url, "offset": offset}).Info("loading dump")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// server ignored the range, starting from scratch
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 {
+			return nil
+		}
+		return fmt.Errorf("unexpected dump response status: %s", resp.Status)
+	default:
+		return fmt.Errorf("unexpected dump response status: %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("dump transfer incomplete: got %d of %d bytes", n, resp.ContentLength)
+	}
+	return nil
+}
+
+// unpackDump moves downloaded data to its final place decompressing it if needed
+func unpackDump(partPath, dumpPath string) error {
+	in, err := os.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	br := bufio.NewReader(in)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return err
+	}
+	if magic[0] != 0x1f || magic[1] != 0x8b {
+		in.Close()
+		return os.Rename(partPath, dumpPath)
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	out, err := os.Create(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, gz); err != nil {
+		os.Remove(dumpPath)
+		return fmt.Errorf("cannot decompress dump: %w", err)
+	}
+	return nil
+}
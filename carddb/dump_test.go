@@ -0,0 +1,224 @@
+package carddb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testDump = []byte(`[
+{"id":"a1","oracle_id":"o1","name":"Lightning Bolt","lang":"en","set":"lea","collector_number":"161"},
+{"id":"a2","oracle_id":"o2","name":"Counterspell","lang":"en","set":"lea","collector_number":"54"}
+]`)
+
+var testUpdatedAt = time.Date(2020, 8, 1, 9, 0, 0, 0, time.UTC)
+
+// scryfallServer imitates bulk-data API and the dump file. Dump requests are served
+// by the handler with ranges supported, the dump is gzipped if the client accepts it and gzipped is set
+type scryfallServer struct {
+	*httptest.Server
+	gzipped bool
+
+	mu     sync.Mutex
+	ranges []string
+	// interrupt is the number of dump requests to be cut in the middle
+	interrupt int
+}
+
+func newScryfallServer() *scryfallServer {
+	s := &scryfallServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bulk-data", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":[{"type":"all_cards","updated_at":%q,"download_uri":%q}]}`,
+			testUpdatedAt.Format(time.RFC3339), s.URL+"/all.json")
+	})
+	mux.HandleFunc("/all.json", s.serveDump)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *scryfallServer) serveDump(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	interrupt := s.interrupt > 0
+	s.interrupt--
+	s.mu.Unlock()
+
+	body := testDump
+	if s.gzipped && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		body = gzipBytes(body)
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if interrupt {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "all.json", testUpdatedAt, bytes.NewReader(body))
+}
+
+func (s *scryfallServer) requestedRanges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.ranges...)
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(b)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "carddb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// partPath is where a download of the test dump is kept until it is complete
+func partPath(dir string) string {
+	return fmt.Sprintf("%s.tmp.%d.part", path.Join(dir, dumpFilename), testUpdatedAt.Unix())
+}
+
+func checkLoaded(t *testing.T, db *CardDB, dir string) {
+	if _, found := db.ByName("Lightning Bolt"); !found {
+		t.Error("card from the dump is not found")
+	}
+	got, err := ioutil.ReadFile(path.Join(dir, dumpFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testDump) {
+		t.Errorf("dump differs from the served one: %q", got)
+	}
+	if parts, _ := filepath.Glob(path.Join(dir, "*.part")); len(parts) != 0 {
+		t.Errorf("partial downloads are left: %v", parts)
+	}
+}
+
+func init() {
+	retryDelay = 0
+}
+
+func TestDownloadResumesInterruptedTransfer(t *testing.T) {
+	srv := newScryfallServer()
+	defer srv.Close()
+	srv.interrupt = 1
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	db := New(Config{Dir: dir, APIURL: srv.URL})
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, db, dir)
+
+	ranges := srv.requestedRanges()
+	want := []string{"", fmt.Sprintf("bytes=%d-", len(testDump)/2)}
+	if fmt.Sprint(ranges) != fmt.Sprint(want) {
+		t.Errorf("requested ranges %q, want %q", ranges, want)
+	}
+}
+
+func TestDownloadGzip(t *testing.T) {
+	srv := newScryfallServer()
+	defer srv.Close()
+	srv.gzipped = true
+	srv.interrupt = 1
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	db := New(Config{Dir: dir, APIURL: srv.URL})
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, db, dir)
+	if ranges := srv.requestedRanges(); len(ranges) != 2 || ranges[1] == "" {
+		t.Errorf("gzipped transfer is not resumed, requested ranges %q", ranges)
+	}
+}
+
+func TestDownloadCompletePartIsNotRequestedAgain(t *testing.T) {
+	srv := newScryfallServer()
+	defer srv.Close()
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(partPath(dir), testDump, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := New(Config{Dir: dir, APIURL: srv.URL})
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, db, dir)
+	want := fmt.Sprintf("bytes=%d-", len(testDump))
+	if ranges := srv.requestedRanges(); len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("requested ranges %q, want only %q answered with 416", ranges, want)
+	}
+}
+
+func TestDownloadRemovesCorruptPart(t *testing.T) {
+	srv := newScryfallServer()
+	defer srv.Close()
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	// gzip magic followed by garbage, as long as the dump so the server answers 416
+	corrupt := append([]byte{0x1f, 0x8b}, bytes.Repeat([]byte{0}, len(testDump)-2)...)
+	if err := ioutil.WriteFile(partPath(dir), corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := New(Config{Dir: dir, APIURL: srv.URL})
+	if err := db.Load(); err == nil {
+		t.Fatal("corrupt dump is loaded")
+	}
+	if _, err := os.Stat(partPath(dir)); !os.IsNotExist(err) {
+		t.Fatalf("corrupt partial dump is kept: %v", err)
+	}
+
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, db, dir)
+}
+
+func TestUpdateSkipsUnchangedDump(t *testing.T) {
+	srv := newScryfallServer()
+	defer srv.Close()
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	db := New(Config{Dir: dir, APIURL: srv.URL})
+	if err := db.Load(); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := db.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Error("dump of the same date is downloaded again")
+	}
+	var meta dumpMeta
+	b, _ := ioutil.ReadFile(path.Join(dir, dumpMetaFilename))
+	if err := json.Unmarshal(b, &meta); err != nil || !meta.UpdatedAt.Equal(testUpdatedAt) {
+		t.Errorf("dump meta %+v, err %v", meta, err)
+	}
+}
@@ -0,0 +1,224 @@
+package carddb
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+)
+
+// NameMatch is a single candidate returned by a name index search
+type NameMatch struct {
+	Name  string
+	Card  Card
+	kind  int
+	score int
+}
+
+// nameIndex resolves exact, partial and misspelled card names
+type nameIndex struct {
+	names    []string // sorted normalized names
+	cards    map[string]Card
+	trigrams map[string][]int // trigram -> positions in names
+}
+
+func newNameIndex(cardsByName map[string]Card) *nameIndex {
+	idx := &nameIndex{
+		names:    make([]string, 0, len(cardsByName)),
+		cards:    cardsByName,
+		trigrams: make(map[string][]int),
+	}
+	for n := range cardsByName {
+		idx.names = append(idx.names, n)
+	}
+	sort.Strings(idx.names)
+	for i, n := range idx.names {
+		for _, t := range nameTrigrams(n) {
+			idx.trigrams[t] = append(idx.trigrams[t], i)
+		}
+	}
+	return idx
+}
+
+// NormalizeName lowercases a name and collapses whitespaces
+func NormalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// nameTrigrams returns unique trigrams of a name padded with spaces
+func nameTrigrams(name string) []string {
+	runes := []rune(" " + name + " ")
+	seen := make(map[string]bool, len(runes))
+	res := make([]string, 0, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		t := string(runes[i : i+3])
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	return res
+}
+
+// find returns the card matching the query. If the query is ambiguous, found is false
+// and candidates contains the best guesses
+func (idx *nameIndex) find(query string, limit int) (card Card, candidates []NameMatch, found bool) {
+	q := NormalizeName(query)
+	if c, ok := idx.cards[q]; ok {
+		return c, nil, true
+	}
+
+	candidates = idx.search(q, limit)
+	if len(candidates) == 1 {
+		return candidates[0].Card, candidates, true
+	}
+	if len(candidates) > 1 && candidates[0].score < candidates[1].score {
+		return candidates[0].Card, candidates, true
+	}
+	return Card{}, candidates, false
+}
+
+// search looks for names starting with, containing or resembling the query.
+// Results are ranked by match kind and edit distance, one entry per card
+func (idx *nameIndex) search(q string, limit int) []NameMatch {
+	if q == "" {
+		return nil
+	}
+
+	matches := idx.searchPrefix(q)
+	if len(matches) == 0 {
+		matches = idx.searchSubstring(q)
+	}
+	if len(matches) == 0 {
+		matches = idx.searchFuzzy(q)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		if len(matches[i].Name) != len(matches[j].Name) {
+			return len(matches[i].Name) < len(matches[j].Name)
+		}
+		// fuzzy matches come from a map, so equally good ones are ordered by name to keep replies stable
+		return matches[i].Name < matches[j].Name
+	})
+
+	res := make([]NameMatch, 0, limit)
+	seen := make(map[string]bool, limit)
+	for _, m := range matches {
+		if seen[m.Card.ID] {
+			continue
+		}
+		seen[m.Card.ID] = true
+		res = append(res, m)
+		if len(res) == limit {
+			break
+		}
+	}
+	return res
+}
+
+func (idx *nameIndex) newMatch(name string, kind int, q string) NameMatch {
+	// prefix and substring matches are equally good, so several of them make the query ambiguous
+	dist := 0
+	if kind == matchFuzzy {
+		dist = levenshtein(q, name)
+	}
+	return NameMatch{
+		Name:  name,
+		Card:  idx.cards[name],
+		kind:  kind,
+		score: kind*100 + dist,
+	}
+}
+
+func (idx *nameIndex) searchPrefix(q string) []NameMatch {
+	res := []NameMatch{}
+	for i := sort.SearchStrings(idx.names, q); i < len(idx.names); i++ {
+		n := idx.names[i]
+		if !strings.HasPrefix(n, q) {
+			break
+		}
+		res = append(res, idx.newMatch(n, matchPrefix, q))
+	}
+	return res
+}
+
+func (idx *nameIndex) searchSubstring(q string) []NameMatch {
+	res := []NameMatch{}
+	for _, n := range idx.names {
+		if strings.Contains(n, q) {
+			res = append(res, idx.newMatch(n, matchSubstring, q))
+		}
+	}
+	return res
+}
+
+func (idx *nameIndex) searchFuzzy(q string) []NameMatch {
+	qTrigrams := nameTrigrams(q)
+	shared := make(map[int]int)
+	for _, t := range qTrigrams {
+		for _, pos := range idx.trigrams[t] {
+			shared[pos]++
+		}
+	}
+
+	maxDist := len([]rune(q)) / 4
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	minShared := len(qTrigrams) / 3
+	if minShared < 1 {
+		minShared = 1
+	}
+
+	res := []NameMatch{}
+	for pos, cnt := range shared {
+		if cnt < minShared {
+			continue
+		}
+		n := idx.names[pos]
+		m := idx.newMatch(n, matchFuzzy, q)
+		if m.score-matchFuzzy*100 > maxDist {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// levenshtein calculates edit distance between two strings rune-wise
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
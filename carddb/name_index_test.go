@@ -0,0 +1,113 @@
+package carddb
+
+import "testing"
+
+func nameTestDB() *CardDB {
+	names := []string{
+		"Brazen Borrower",
+		"Lightning Bolt",
+		"Lightning Helix",
+		"Lightning Greaves",
+		"Chain Lightning",
+		"Counterspell",
+		"Shock",
+		"Shack",
+	}
+	cards := make([]Card, 0, len(names))
+	for _, n := range names {
+		cards = append(cards, Card{ID: n, OracleID: n, Name: n, Lang: "en"})
+	}
+	return testDB(cards...)
+}
+
+func TestFindName(t *testing.T) {
+	db := nameTestDB()
+	cases := []struct {
+		query string
+		want  string // empty if the query is ambiguous or unknown
+	}{
+		{"  lightning   BOLT ", "Lightning Bolt"},
+		// partial names
+		{"brazen borr", "Brazen Borrower"},
+		{"counterspel", "Counterspell"},
+		{"borrower", "Brazen Borrower"},
+		{"bolt", "Lightning Bolt"},
+		// misspelled names
+		{"lightnig bolt", "Lightning Bolt"},
+		{"lihgtning helix", "Lightning Helix"},
+		{"cuonterspell", "Counterspell"},
+		// top two scores tie
+		{"lightning", ""},
+		{"shick", ""},
+		{"xyzzy", ""},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		c, _, found := db.FindName(tc.query, 5)
+		if tc.want == "" {
+			if found {
+				t.Errorf("%q is resolved to %s, want it ambiguous", tc.query, c.Name)
+			}
+			continue
+		}
+		if !found || c.Name != tc.want {
+			t.Errorf("%q is resolved to %q (found %v), want %q", tc.query, c.Name, found, tc.want)
+		}
+	}
+}
+
+func TestFindNameCandidates(t *testing.T) {
+	db := nameTestDB()
+	cases := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		// equally good prefix matches go from the shortest name
+		{"lightning", 5, []string{"lightning bolt", "lightning helix", "lightning greaves"}},
+		{"lightning", 2, []string{"lightning bolt", "lightning helix"}},
+		// prefix matches win over substring ones
+		{"chain", 5, []string{"chain lightning"}},
+		{"shick", 5, []string{"shack", "shock"}},
+		{"xyzzy", 5, []string{}},
+	}
+	for _, tc := range cases {
+		_, candidates, _ := db.FindName(tc.query, tc.limit)
+		if len(candidates) != len(tc.want) {
+			t.Errorf("%q: got %d candidates %+v, want %q", tc.query, len(candidates), candidates, tc.want)
+			continue
+		}
+		for i, m := range candidates {
+			if m.Name != tc.want[i] {
+				t.Errorf("%q: candidate %d is %q, want %q", tc.query, i, m.Name, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestSuggestNamesExact(t *testing.T) {
+	db := nameTestDB()
+	got := db.SuggestNames("Lightning Bolt", 5)
+	if len(got) != 1 || got[0].Card.Name != "Lightning Bolt" {
+		t.Errorf("exact name suggests %+v", got)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"bolt", "", 4},
+		{"bolt", "bolt", 0},
+		{"lightnig", "lightning", 1},
+		{"cuonterspell", "counterspell", 2},
+		{"молния", "малния", 1},
+	}
+	for _, tc := range cases {
+		if got := levenshtein(tc.a, tc.b); got != tc.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package carddb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cardFilter reports whether a card satisfies a part of a search query
+type cardFilter func(c *Card) bool
+
+var termRe = regexp.MustCompile(`^([a-zA-Z]+)(:|!=|<=|>=|=|<|>)(.+)$`)
+
+type searchTerm struct {
+	key, op, value string
+}
+
+type searchTermParser func(t searchTerm) (cardFilter, error)
+
+var searchKeywords = map[string]searchTermParser{
+	"t":         parseTypeTerm,
+	"type":      parseTypeTerm,
+	"o":         parseOracleTerm,
+	"oracle":    parseOracleTerm,
+	"c":         parseColorTerm,
+	"color":     parseColorTerm,
+	"id":        parseIdentityTerm,
+	"identity":  parseIdentityTerm,
+	"m":         parseManaTerm,
+	"mana":      parseManaTerm,
+	"cmc":       parseCMCTerm,
+	"mv":        parseCMCTerm,
+	"pow":       parsePowerTerm,
+	"power":     parsePowerTerm,
+	"tou":       parseToughnessTerm,
+	"toughness": parseToughnessTerm,
+	"s":         parseSetTerm,
+	"set":       parseSetTerm,
+	"e":         parseSetTerm,
+	"edition":   parseSetTerm,
+	"r":         parseRarityTerm,
+	"rarity":    parseRarityTerm,
+	"f":         parseFormatTerm,
+	"format":    parseFormatTerm,
+	"legal":     parseFormatTerm,
+	"banned":    parseBannedTerm,
+}
+
+// IsSearchQuery reports whether the request uses search syntax rather than being a card name
+func IsSearchQuery(req string) bool {
+	for _, tok := range tokenizeQuery(req) {
+		if _, ok := splitTerm(strings.TrimPrefix(tok, "-")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizeQuery splits a query into words and parentheses keeping quoted parts intact
+func tokenizeQuery(q string) []string {
+	tokens := []string{}
+	cur := strings.Builder{}
+	inQuotes := false
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			cur.WriteRune(r)
+		case inQuotes:
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func splitTerm(tok string) (searchTerm, bool) {
+	m := termRe.FindStringSubmatch(tok)
+	if m == nil {
+		return searchTerm{}, false
+	}
+	key := strings.ToLower(m[1])
+	if _, found := searchKeywords[key]; !found {
+		return searchTerm{}, false
+	}
+	return searchTerm{key: key, op: m[2], value: strings.ToLower(strings.Trim(m[3], `"`))}, true
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseSearchQuery compiles a Scryfall-like query into a single filter
+func parseSearchQuery(q string) (cardFilter, error) {
+	p := &queryParser{tokens: tokenizeQuery(q)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+func (p *queryParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) parseOr() (cardFilter, error) {
+	f, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.ToLower(p.peek()) == "or" {
+		p.pos++
+		other, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left := f
+		f = func(c *Card) bool { return left(c) || other(c) }
+	}
+	return f, nil
+}
+
+func (p *queryParser) parseAnd() (cardFilter, error) {
+	filters := []cardFilter{}
+	for {
+		tok := p.peek()
+		if tok == "" || tok == ")" || strings.ToLower(tok) == "or" {
+			break
+		}
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return func(c *Card) bool {
+		for _, f := range filters {
+			if !f(c) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (p *queryParser) parseUnary() (cardFilter, error) {
+	tok := p.peek()
+	if tok == "(" {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return f, nil
+	}
+
+	p.pos++
+	if tok == "-" && p.peek() == "(" {
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *Card) bool { return !f(c) }, nil
+	}
+	negate := false
+	if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+		negate = true
+		tok = tok[1:]
+	}
+	f, err := parseTerm(tok)
+	if err != nil {
+		return nil, err
+	}
+	if negate {
+		return func(c *Card) bool { return !f(c) }, nil
+	}
+	return f, nil
+}
+
+func parseTerm(tok string) (cardFilter, error) {
+	if t, ok := splitTerm(tok); ok {
+		return searchKeywords[t.key](t)
+	}
+	if m := termRe.FindStringSubmatch(tok); m != nil && !strings.Contains(m[3], " ") {
+		return nil, fmt.Errorf("unknown keyword %q", m[1])
+	}
+
+	name := NormalizeName(strings.Trim(tok, `"`))
+	return func(c *Card) bool {
+		return strings.Contains(strings.ToLower(c.Name), name) || strings.Contains(strings.ToLower(c.LocalName), name)
+	}, nil
+}
+
+func containsTerm(t searchTerm, field func(c *Card) string) (cardFilter, error) {
+	if t.op != ":" && t.op != "=" {
+		return nil, fmt.Errorf("keyword %q supports only ':'", t.key)
+	}
+	return func(c *Card) bool {
+		return strings.Contains(strings.ToLower(field(c)), t.value)
+	}, nil
+}
+
+func parseTypeTerm(t searchTerm) (cardFilter, error) {
+	return containsTerm(t, (*Card).FullTypeLine)
+}
+
+func parseOracleTerm(t searchTerm) (cardFilter, error) {
+	return containsTerm(t, (*Card).FullOracleText)
+}
+
+// parseManaTerm matches cards which cost has at least the symbols of the value
+func parseManaTerm(t searchTerm) (cardFilter, error) {
+	want := manaSymbols(t.value)
+	if t.op != ":" && t.op != "=" {
+		return nil, fmt.Errorf("keyword %q supports only ':'", t.key)
+	}
+	return func(c *Card) bool {
+		have := manaSymbols(c.FullManaCost())
+		for sym, n := range want {
+			if have[sym] < n {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// manaSymbols counts whole symbols of both "{10}{U/B}{U}" and "2uu" forms,
+// so "1" is not a part of "{10}" and "U" is not a part of "{U/B}"
+func manaSymbols(s string) map[string]int {
+	s = strings.ToUpper(s)
+	symbols := make(map[string]int)
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				end = len(s) - i
+			}
+			symbols[s[i+1:i+end]]++
+			i += end + 1
+		case s[i] >= '0' && s[i] <= '9':
+			start := i
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			symbols[s[start:i]]++
+		case s[i] >= 'A' && s[i] <= 'Z':
+			symbols[s[i:i+1]]++
+			i++
+		default:
+			i++
+		}
+	}
+	return symbols
+}
+
+func compareNumbers(op string, a, b float64) bool {
+	switch op {
+	case ":", "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func numericTerm(t searchTerm, field func(c *Card) (float64, bool)) (cardFilter, error) {
+	want, err := strconv.ParseFloat(t.value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("keyword %q requires a number, got %q", t.key, t.value)
+	}
+	return func(c *Card) bool {
+		v, ok := field(c)
+		return ok && compareNumbers(t.op, v, want)
+	}, nil
+}
+
+func parseCMCTerm(t searchTerm) (cardFilter, error) {
+	return numericTerm(t, func(c *Card) (float64, bool) { return c.CMC, true })
+}
+
+func parsePowerTerm(t searchTerm) (cardFilter, error) {
+	return numericTerm(t, func(c *Card) (float64, bool) { return parseStat(c.Power) })
+}
+
+func parseToughnessTerm(t searchTerm) (cardFilter, error) {
+	return numericTerm(t, func(c *Card) (float64, bool) { return parseStat(c.Toughness) })
+}
+
+func parseStat(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+var colorNames = map[string]string{
+	"white": "w", "blue": "u", "black": "b", "red": "r", "green": "g",
+	"azorius": "wu", "dimir": "ub", "rakdos": "br", "gruul": "rg", "selesnya": "gw",
+	"orzhov": "wb", "izzet": "ur", "golgari": "bg", "boros": "rw", "simic": "gu",
+	"colorless": "c",
+}
+
+// parseColors converts "ug", "simic" or "colorless" into a set of color letters
+func parseColors(value string) (map[string]bool, error) {
+	if named, found := colorNames[value]; found {
+		value = named
+	}
+	colors := make(map[string]bool, len(value))
+	if value == "c" {
+		return colors, nil
+	}
+	for _, r := range value {
+		l := string(r)
+		if !strings.Contains("wubrg", l) {
+			return nil, fmt.Errorf("unknown color %q", l)
+		}
+		colors[l] = true
+	}
+	return colors, nil
+}
+
+func compareColors(op string, have, want map[string]bool) bool {
+	subset := func(a, b map[string]bool) bool {
+		for k := range a {
+			if !b[k] {
+				return false
+			}
+		}
+		return true
+	}
+	switch op {
+	case ":", ">=":
+		return subset(want, have)
+	case "=":
+		return subset(want, have) && subset(have, want)
+	case "!=":
+		return !(subset(want, have) && subset(have, want))
+	case "<=":
+		return subset(have, want)
+	case "<":
+		return subset(have, want) && len(have) < len(want)
+	case ">":
+		return subset(want, have) && len(have) > len(want)
+	}
+	return false
+}
+
+func colorTerm(t searchTerm, field func(c *Card) []string, defaultOp string) (cardFilter, error) {
+	if t.value == "m" || t.value == "multicolor" {
+		return func(c *Card) bool { return len(field(c)) > 1 }, nil
+	}
+	want, err := parseColors(t.value)
+	if err != nil {
+		return nil, err
+	}
+	op := t.op
+	if op == ":" {
+		op = defaultOp
+	}
+	return func(c *Card) bool {
+		have := make(map[string]bool, 5)
+		for _, col := range field(c) {
+			have[strings.ToLower(col)] = true
+		}
+		if len(want) == 0 && op != "!=" {
+			return len(have) == 0
+		}
+		return compareColors(op, have, want)
+	}, nil
+}
+
+func parseColorTerm(t searchTerm) (cardFilter, error) {
+	return colorTerm(t, (*Card).AllColors, ">=")
+}
+
+func parseIdentityTerm(t searchTerm) (cardFilter, error) {
+	// commander identity is usually asked as "fits into this deck"
+	return colorTerm(t, func(c *Card) []string { return c.ColorIdentity }, "<=")
+}
+
+func parseSetTerm(t searchTerm) (cardFilter, error) {
+	switch t.op {
+	case ":", "=":
+		return func(c *Card) bool { return strings.ToLower(c.Set) == t.value }, nil
+	case "!=":
+		return func(c *Card) bool { return strings.ToLower(c.Set) != t.value }, nil
+	}
+	return nil, fmt.Errorf("keyword %q supports only ':' and '!='", t.key)
+}
+
+var rarities = map[string]int{"common": 0, "c": 0, "uncommon": 1, "u": 1, "rare": 2, "r": 2, "mythic": 3, "m": 3, "special": 4, "bonus": 5}
+
+func parseRarityTerm(t searchTerm) (cardFilter, error) {
+	want, found := rarities[t.value]
+	if !found {
+		return nil, fmt.Errorf("unknown rarity %q", t.value)
+	}
+	return func(c *Card) bool {
+		have, found := rarities[c.Rarity]
+		return found && compareNumbers(t.op, float64(have), float64(want))
+	}, nil
+}
+
+func parseFormatTerm(t searchTerm) (cardFilter, error) {
+	if t.op != ":" && t.op != "=" {
+		return nil, fmt.Errorf("keyword %q supports only ':'", t.key)
+	}
+	return func(c *Card) bool {
+		l := c.Legalities[t.value]
+		return l == "legal" || l == "restricted"
+	}, nil
+}
+
+func parseBannedTerm(t searchTerm) (cardFilter, error) {
+	if t.op != ":" && t.op != "=" {
+		return nil, fmt.Errorf("keyword %q supports only ':'", t.key)
+	}
+	return func(c *Card) bool { return c.Legalities[t.value] == "banned" }, nil
+}
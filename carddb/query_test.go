@@ -0,0 +1,185 @@
+package carddb
+
+import (
+	"strings"
+	"testing"
+)
+
+func queryTestDB() *CardDB {
+	legal := func(formats ...string) map[string]string {
+		l := map[string]string{"modern": "not_legal", "legacy": "legal", "pauper": "not_legal"}
+		for _, f := range formats {
+			kv := strings.Split(f, "=")
+			l[kv[0]] = kv[1]
+		}
+		return l
+	}
+	card := func(name, typeLine, cost string, cmc float64, colors string, pt string, set, rarity string, legalities map[string]string, text string) Card {
+		c := Card{
+			ID: name, OracleID: name, Name: name, Lang: "en",
+			TypeLine: typeLine, ManaCost: cost, CMC: cmc, OracleText: text,
+			Set: set, Rarity: rarity, Legalities: legalities,
+		}
+		for _, col := range colors {
+			c.Colors = append(c.Colors, strings.ToUpper(string(col)))
+		}
+		c.ColorIdentity = c.Colors
+		if pt != "" {
+			stats := strings.Split(pt, "/")
+			c.Power, c.Toughness = stats[0], stats[1]
+		}
+		return c
+	}
+	return testDB(
+		card("Llanowar Elves", "Creature — Elf Druid", "{G}", 1, "g", "1/1", "m19", "common", legal("modern=legal"), "{T}: Add {G}."),
+		card("Goblin Guide", "Creature — Goblin Scout", "{R}", 1, "r", "2/2", "zen", "rare", legal("modern=legal"), "Haste"),
+		card("Counterspell", "Instant", "{U}{U}", 2, "u", "", "mh2", "common", legal("pauper=legal"), "Counter target spell."),
+		card("Brazen Borrower", "Creature — Faerie Rogue // Instant — Adventure", "{1}{U}{U} // {1}{U}", 3, "u", "3/1", "eld", "mythic", legal("modern=legal"), "Flash\nFlying"),
+		card("Ulamog, the Ceaseless Hunger", "Legendary Creature — Eldrazi", "{10}", 10, "", "10/10", "bfz", "mythic", legal("modern=legal"), "Indestructible"),
+		card("Kitchen Finks", "Creature — Ouphe", "{1}{G/W}{G/W}", 3, "gw", "3/2", "shm", "uncommon", legal("modern=legal"), "Persist"),
+		card("Dimir Guildmage", "Creature — Human Wizard", "{U/B}{U/B}", 2, "ub", "2/2", "dis", "common", legal("modern=legal"), "Draw a card."),
+		card("Treasure Cruise", "Sorcery", "{7}{U}", 8, "u", "", "ktk", "common", legal("modern=banned"), "Delve\nDraw three cards."),
+	)
+}
+
+func TestSearch(t *testing.T) {
+	db := queryTestDB()
+	cases := []struct {
+		query string
+		want  string // names joined by "; " in alphabetical order
+	}{
+		// precedence of or, and and parentheses
+		{"t:goblin or t:elf c:g", "Goblin Guide; Llanowar Elves"},
+		{"(t:goblin or t:elf) c:r", "Goblin Guide"},
+		{"t:creature c:u or t:sorcery", "Brazen Borrower; Dimir Guildmage; Treasure Cruise"},
+		{"((t:goblin))", "Goblin Guide"},
+		{"t:goblin OR (t:elf or t:ouphe) cmc:3", "Goblin Guide; Kitchen Finks"},
+
+		// negation
+		{"t:creature -c:g", "Brazen Borrower; Dimir Guildmage; Goblin Guide; Ulamog, the Ceaseless Hunger"},
+		{"t:creature -(t:goblin or t:elf)", "Brazen Borrower; Dimir Guildmage; Kitchen Finks; Ulamog, the Ceaseless Hunger"},
+		{"-(t:creature)", "Counterspell; Treasure Cruise"},
+		{"-t:creature -t:instant", "Treasure Cruise"},
+
+		// names
+		{"goblin c:r", "Goblin Guide"},
+		{`"kitchen finks"`, "Kitchen Finks"},
+
+		{"t:eldrazi", "Ulamog, the Ceaseless Hunger"},
+		{"type=instant", "Brazen Borrower; Counterspell"},
+		{`o:"counter target"`, "Counterspell"},
+		{`oracle:"draw three"`, "Treasure Cruise"},
+
+		{"c:gw", "Kitchen Finks"},
+		{"c>=u", "Brazen Borrower; Counterspell; Dimir Guildmage; Treasure Cruise"},
+		{"c=u", "Brazen Borrower; Counterspell; Treasure Cruise"},
+		{"c!=u", "Dimir Guildmage; Goblin Guide; Kitchen Finks; Llanowar Elves; Ulamog, the Ceaseless Hunger"},
+		{"c<=ub", "Brazen Borrower; Counterspell; Dimir Guildmage; Treasure Cruise; Ulamog, the Ceaseless Hunger"},
+		{"c<ub", "Brazen Borrower; Counterspell; Treasure Cruise; Ulamog, the Ceaseless Hunger"},
+		{"c>u", "Dimir Guildmage"},
+		{"c:c", "Ulamog, the Ceaseless Hunger"},
+		{"color:m", "Dimir Guildmage; Kitchen Finks"},
+		{"c:simic", ""},
+		{"id:g", "Llanowar Elves; Ulamog, the Ceaseless Hunger"},
+		{"identity=g", "Llanowar Elves"},
+		{"id>=gw", "Kitchen Finks"},
+
+		// whole mana symbols
+		{"m:1", "Brazen Borrower; Kitchen Finks"},
+		{"m:10", "Ulamog, the Ceaseless Hunger"},
+		{"m:u", "Brazen Borrower; Counterspell; Treasure Cruise"},
+		{"m:uu", "Brazen Borrower; Counterspell"},
+		{"m:{U/B}", "Dimir Guildmage"},
+		{"mana={g/w}{g/w}", "Kitchen Finks"},
+		{"m:{7}u", "Treasure Cruise"},
+
+		{"cmc:2", "Counterspell; Dimir Guildmage"},
+		{"cmc=3", "Brazen Borrower; Kitchen Finks"},
+		{"cmc!=1 cmc<3", "Counterspell; Dimir Guildmage"},
+		{"cmc<2", "Goblin Guide; Llanowar Elves"},
+		{"cmc<=1", "Goblin Guide; Llanowar Elves"},
+		{"mv>8", "Ulamog, the Ceaseless Hunger"},
+		{"mv>=8", "Treasure Cruise; Ulamog, the Ceaseless Hunger"},
+
+		{"pow>=3", "Brazen Borrower; Kitchen Finks; Ulamog, the Ceaseless Hunger"},
+		{"power!=2", "Brazen Borrower; Kitchen Finks; Llanowar Elves; Ulamog, the Ceaseless Hunger"},
+		{"tou<2", "Brazen Borrower; Llanowar Elves"},
+		{"toughness=2 pow:2", "Dimir Guildmage; Goblin Guide"},
+
+		{"s:m19", "Llanowar Elves"},
+		{"set=zen", "Goblin Guide"},
+		{"e!=m19 t:creature", "Brazen Borrower; Dimir Guildmage; Goblin Guide; Kitchen Finks; Ulamog, the Ceaseless Hunger"},
+
+		{"r:mythic", "Brazen Borrower; Ulamog, the Ceaseless Hunger"},
+		{"r>=rare", "Brazen Borrower; Goblin Guide; Ulamog, the Ceaseless Hunger"},
+		{"rarity<uncommon", "Counterspell; Dimir Guildmage; Llanowar Elves; Treasure Cruise"},
+		{"r!=c t:creature", "Brazen Borrower; Goblin Guide; Kitchen Finks; Ulamog, the Ceaseless Hunger"},
+
+		{"f:pauper", "Counterspell"},
+		{"format=modern t:instant", "Brazen Borrower"},
+		{"legal:legacy c:u", "Brazen Borrower; Counterspell; Dimir Guildmage; Treasure Cruise"},
+		{"banned:modern", "Treasure Cruise"},
+	}
+	for _, tc := range cases {
+		cards, err := db.Search(tc.query)
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+		names := make([]string, 0, len(cards))
+		for _, c := range cards {
+			names = append(names, c.Name)
+		}
+		if got := strings.Join(names, "; "); got != tc.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	db := queryTestDB()
+	for _, q := range []string{
+		"",
+		"foo:bar",
+		"t<creature",
+		"o!=draw",
+		"m>u",
+		"c:x",
+		"cmc:x",
+		"pow>big",
+		"s>m19",
+		"r:x",
+		"f<modern",
+		"legal!=modern",
+		"banned>=modern",
+		"(t:goblin",
+		"t:goblin)",
+		"()",
+		"or t:goblin",
+		"t:goblin or",
+		"-(t:goblin",
+	} {
+		if _, err := db.Search(q); err == nil {
+			t.Errorf("%q is accepted", q)
+		}
+	}
+}
+
+func TestIsSearchQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"lightning bolt", false},
+		{"t:goblin", true},
+		{"-c:r", true},
+		{"-(t:goblin or t:elf)", true},
+		{"Borrower's Tale", false},
+		{"foo:bar", false},
+	}
+	for _, tc := range cases {
+		if got := IsSearchQuery(tc.query); got != tc.want {
+			t.Errorf("IsSearchQuery(%q) = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package comprules keeps a local copy of the Magic: The Gathering Comprehensive Rules
+package comprules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultURL is the page of Wizards of the Coast linking the current rules
+	DefaultURL = "https://magic.wizards.com/en/rules"
+
+	rulesFilename     = "comprules.txt"
+	rulesMetaFilename = "comprules.meta.json"
+)
+
+// txtLinkRe finds the link to the text version of the rules on the rules page
+var txtLinkRe = regexp.MustCompile(`https?://[^"'\s<>]+\.txt`)
+
+// Config describes where the rules are stored and where they are taken from
+type Config struct {
+	Dir string
+	// URL is either the text file of the rules or a page linking it
+	URL string
+}
+
+// rulesMeta is stored next to the rules to know which file they were taken from
+type rulesMeta struct {
+	URL string `json:"url"`
+}
+
+// RulesDB provides lookups over the rules, the parsed document is swapped as a whole on update
+type RulesDB struct {
+	cfg Config
+
+	mu  sync.RWMutex
+	doc *document
+
+	updateMu sync.Mutex
+}
+
+func New(cfg Config) *RulesDB {
+	if cfg.URL == "" {
+		cfg.URL = DefaultURL
+	}
+	return &RulesDB{
+		cfg: cfg,
+		doc: &document{byNumber: map[string]int{}, byTerm: map[string]int{}},
+	}
+}
+
+// Load reads the rules from the disk, downloading them first if they are absent
+func (db *RulesDB) Load() error {
+	if err := os.MkdirAll(db.cfg.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	rulesPath := path.Join(db.cfg.Dir, rulesFilename)
+	if _, err := os.Stat(rulesPath); os.IsNotExist(err) {
+		log.WithFields(log.Fields{"rulesPath": rulesPath}).Info("comprehensive rules are absent, loading")
+		_, err := db.Update()
+		return err
+	}
+	doc, err := parseFile(rulesPath)
+	if err != nil {
+		return err
+	}
+	db.swap(doc)
+	return nil
+}
+
+// Update downloads the rules if a newer file has been published
+func (db *RulesDB) Update() (bool, error) {
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+
+	txtURL, err := db.textURL()
+	if err != nil {
+		return false, fmt.Errorf("cannot find rules text: %w", err)
+	}
+	metaPath := path.Join(db.cfg.Dir, rulesMetaFilename)
+	rulesPath := path.Join(db.cfg.Dir, rulesFilename)
+	var meta rulesMeta
+	if b, err := ioutil.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(b, &meta); err != nil {
+			log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Warn("cannot read rules meta, rules will be reloaded")
+		}
+	}
+	if _, err := os.Stat(rulesPath); err == nil && meta.URL == txtURL {
+		log.WithFields(log.Fields{"url": txtURL}).Debug("comprehensive rules are up to date")
+		return false, nil
+	}
+
+	log.WithFields(log.Fields{"current": meta.URL, "new": txtURL}).Info("loading comprehensive rules")
+	tmpPath := rulesPath + ".tmp"
+	defer os.Remove(tmpPath)
+	if err := download(txtURL, tmpPath); err != nil {
+		return false, err
+	}
+	doc, err := parseFile(tmpPath)
+	if err != nil {
+		return false, err
+	}
+	if len(doc.rules) == 0 {
+		return false, errors.New("downloaded file has no rules")
+	}
+	if err := os.Rename(tmpPath, rulesPath); err != nil {
+		return false, err
+	}
+	b, _ := json.Marshal(rulesMeta{URL: txtURL})
+	if err := ioutil.WriteFile(metaPath, b, 0644); err != nil {
+		log.WithFields(log.Fields{"metaPath": metaPath, "err": err}).Error("cannot write rules meta")
+	}
+	db.swap(doc)
+	log.WithFields(log.Fields{"effective": doc.effective, "rules": len(doc.rules), "glossary": len(doc.glossary)}).Info("comprehensive rules have been updated")
+	return true, nil
+}
+
+// textURL returns the address of the text file, looking it up on the rules page if needed
+func (db *RulesDB) textURL() (string, error) {
+	if strings.HasSuffix(strings.ToLower(db.cfg.URL), ".txt") {
+		return db.cfg.URL, nil
+	}
+	resp, err := http.Get(db.cfg.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected rules page response status: %s", resp.Status)
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return "", err
+	}
+	link := txtLinkRe.Find(page)
+	if link == nil {
+		return "", fmt.Errorf("no link to rules text at %s", db.cfg.URL)
+	}
+	// links on the page have spaces encoded, keep the canonical form to compare with the stored one
+	u, err := url.Parse(string(link))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func download(addr, dst string) error {
+	resp, err := http.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected rules response status: %s", resp.Status)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func parseFile(p string) (*document, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+func (db *RulesDB) current() *document {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.doc
+}
+
+func (db *RulesDB) swap(doc *document) {
+	db.mu.Lock()
+	db.doc = doc
+	db.mu.Unlock()
+}
+
+// Effective returns the sentence telling since when the loaded rules are in effect
+func (db *RulesDB) Effective() string {
+	return db.current().effective
+}
+
+// Rule returns the rule by its number with the rules it consists of:
+// lettered subrules for a rule and numbered rules for a section
+func (db *RulesDB) Rule(number string) (Rule, []Rule, bool) {
+	doc := db.current()
+	number = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(number)), ".")
+	i, found := doc.byNumber[number]
+	if !found {
+		return Rule{}, nil, false
+	}
+	r := doc.rules[i]
+	if !strings.Contains(number, ".") {
+		return r, doc.sectionRules(number), true
+	}
+	return r, doc.subrules(number), true
+}
+
+// Glossary returns the glossary entry of the term ignoring case
+func (db *RulesDB) Glossary(term string) (GlossaryEntry, bool) {
+	doc := db.current()
+	i, found := doc.byTerm[strings.ToLower(strings.TrimSpace(term))]
+	if !found {
+		return GlossaryEntry{}, false
+	}
+	return doc.glossary[i], true
+}
+
+// Search returns up to limit rules mentioning the text ignoring case, in order of the document
+func (db *RulesDB) Search(text string, limit int) []Rule {
+	doc := db.current()
+	text = strings.ToLower(strings.TrimSpace(text))
+	res := []Rule{}
+	if text == "" {
+		return res
+	}
+	for _, r := range doc.rules {
+		if strings.Contains(strings.ToLower(r.Text), text) {
+			res = append(res, r)
+			if len(res) == limit {
+				break
+			}
+		}
+	}
+	return res
+}
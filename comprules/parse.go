@@ -0,0 +1,147 @@
+package comprules
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Rule is a single numbered rule, its examples are kept in the text
+type Rule struct {
+	Number string
+	Text   string
+}
+
+// GlossaryEntry is a term defined in the glossary of the rules
+type GlossaryEntry struct {
+	Term string
+	Text string
+}
+
+type document struct {
+	effective string
+	rules     []Rule         // in order of the document
+	byNumber  map[string]int // number without trailing dot -> position in rules
+	glossary  []GlossaryEntry
+	byTerm    map[string]int // lowercase term -> position in glossary
+}
+
+var (
+	// ruleRe matches "100. General", "100.1. These Magic rules..." and "100.1a A two-player game..."
+	ruleRe      = regexp.MustCompile(`^(\d{3}(?:\.\d+[a-z]?)?)\.?\s+(.+)$`)
+	effectiveRe = regexp.MustCompile(`(?i)^these rules are effective as of`)
+)
+
+type parseState int
+
+const (
+	stateIntro parseState = iota
+	stateRules
+	stateGlossary
+	stateCredits
+)
+
+// parse reads the text version of the rules. Contents at the beginning of the document repeat
+// section names and the glossary title, the glossary is taken only when it follows numbered rules
+func parse(r io.Reader) (*document, error) {
+	doc := &document{
+		byNumber: make(map[string]int),
+		byTerm:   make(map[string]int),
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	state := stateIntro
+	var entry *GlossaryEntry
+	last := -1 // rule the following example lines belong to
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		switch state {
+		case stateIntro, stateRules:
+			if effectiveRe.MatchString(line) && doc.effective == "" {
+				doc.effective = strings.TrimSuffix(line, ".")
+			}
+			if line == "Glossary" && state == stateRules {
+				state = stateGlossary
+				continue
+			}
+			if m := ruleRe.FindStringSubmatch(line); m != nil {
+				if strings.Contains(m[1], ".") {
+					state = stateRules
+				}
+				if i, found := doc.byNumber[m[1]]; found {
+					// section names are listed in the contents as well
+					doc.rules[i].Text = m[2]
+					last = i
+					continue
+				}
+				doc.byNumber[m[1]] = len(doc.rules)
+				doc.rules = append(doc.rules, Rule{Number: m[1], Text: m[2]})
+				last = len(doc.rules) - 1
+				continue
+			}
+			if line == "" {
+				continue
+			}
+			if state == stateRules && last >= 0 && strings.HasPrefix(line, "Example:") {
+				doc.rules[last].Text += "\n" + line
+			}
+		case stateGlossary:
+			if line == "" {
+				if entry != nil {
+					doc.byTerm[strings.ToLower(entry.Term)] = len(doc.glossary)
+					doc.glossary = append(doc.glossary, *entry)
+					entry = nil
+				}
+				continue
+			}
+			if line == "Credits" && entry == nil {
+				state = stateCredits
+				continue
+			}
+			if entry == nil {
+				entry = &GlossaryEntry{Term: line}
+			} else if entry.Text == "" {
+				entry.Text = line
+			} else {
+				entry.Text += "\n" + line
+			}
+		case stateCredits:
+		}
+	}
+	if entry != nil {
+		doc.byTerm[strings.ToLower(entry.Term)] = len(doc.glossary)
+		doc.glossary = append(doc.glossary, *entry)
+	}
+	return doc, sc.Err()
+}
+
+// sectionRules lists rules of the first level of the section, e.g. 702.1 and 702.2 for 702
+func (doc *document) sectionRules(section string) []Rule {
+	res := []Rule{}
+	for _, r := range doc.rules {
+		rest := strings.TrimPrefix(r.Number, section+".")
+		if rest == r.Number || rest == "" {
+			continue
+		}
+		if last := rest[len(rest)-1]; last < '0' || last > '9' {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// subrules lists lettered subrules of the rule, e.g. 702.19a and 702.19b for 702.19
+func (doc *document) subrules(number string) []Rule {
+	res := []Rule{}
+	for _, r := range doc.rules {
+		rest := strings.TrimPrefix(r.Number, number)
+		if rest == r.Number || len(rest) != 1 || rest[0] < 'a' || rest[0] > 'z' {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
@@ -0,0 +1,154 @@
+package comprules
+
+import (
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testRulesDB(t *testing.T, text string) *RulesDB {
+	doc, err := parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := New(Config{})
+	db.swap(doc)
+	return db
+}
+
+func readExcerpt(t *testing.T) string {
+	b, err := ioutil.ReadFile(path.Join("testdata", "rules_excerpt.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func numbers(rules []Rule) string {
+	res := make([]string, 0, len(rules))
+	for _, r := range rules {
+		res = append(res, r.Number)
+	}
+	return strings.Join(res, " ")
+}
+
+func TestParseRuleNumbers(t *testing.T) {
+	excerpt := readExcerpt(t)
+	// the published file has windows line endings
+	for _, text := range []string{excerpt, strings.ReplaceAll(excerpt, "\n", "\r\n")} {
+		db := testRulesDB(t, text)
+		doc := db.current()
+
+		if doc.effective != "These rules are effective as of November 18, 2022" {
+			t.Errorf("effective is %q", doc.effective)
+		}
+		numberRe := regexp.MustCompile(`^\d{3}(\.\d+[a-z]?)?$`)
+		for _, r := range doc.rules {
+			if !numberRe.MatchString(r.Number) {
+				t.Errorf("rule %q has a malformed number", r.Number)
+			}
+			if r.Text == "" || strings.HasSuffix(r.Text, "\r") {
+				t.Errorf("rule %s has text %q", r.Number, r.Text)
+			}
+		}
+		if len(doc.rules) != 16 {
+			t.Errorf("got %d rules: %s", len(doc.rules), numbers(doc.rules))
+		}
+
+		cases := []struct {
+			number, text, parts string
+		}{
+			// section names from the contents are replaced by the ones of the rules
+			{"100", "General", "100.1 100.2"},
+			{"100.", "General", "100.1 100.2"},
+			{"100.1", "These Magic rules apply to any Magic game with two or more players, including two-player games and multiplayer games.", "100.1a 100.1b"},
+			{"100.1b", `A multiplayer game is a game that begins with more than two players. See section 8, "Multiplayer Rules."`, ""},
+			{"101.1.", "Whenever a card's text directly contradicts these rules, the card takes precedence. The card overrides only the rule that applies to that specific situation.", ""},
+			{"702", "Keyword Abilities", "702.1 702.2 702.19"},
+			{"702.2", "Deathtouch", "702.2a 702.2b 702.2c"},
+			{"702.19", "Trample", "702.19a 702.19b"},
+			{" 702.19A ", "Trample is a static ability that modifies the rules for assigning an attacking creature's combat damage.", ""},
+		}
+		for _, tc := range cases {
+			r, parts, found := db.Rule(tc.number)
+			if !found {
+				t.Errorf("rule %q is not found", tc.number)
+				continue
+			}
+			if r.Text != tc.text {
+				t.Errorf("rule %q has text %q, want %q", tc.number, r.Text, tc.text)
+			}
+			if got := numbers(parts); got != tc.parts {
+				t.Errorf("rule %q consists of %q, want %q", tc.number, got, tc.parts)
+			}
+		}
+		for _, missing := range []string{"1", "7", "702.3", "8", ""} {
+			if _, _, found := db.Rule(missing); found {
+				t.Errorf("rule %q is found", missing)
+			}
+		}
+	}
+}
+
+func TestParseContinuationLines(t *testing.T) {
+	db := testRulesDB(t, readExcerpt(t))
+
+	// examples right after the rule and after an empty line are both kept
+	r, _, _ := db.Rule("702.2c")
+	lines := strings.Split(r.Text, "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "Example: A creature with deathtouch and 3 power") {
+		t.Errorf("702.2c has text %q", r.Text)
+	}
+	r, _, _ = db.Rule("702.19b")
+	lines = strings.Split(r.Text, "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "Example: A 6/6") || !strings.HasPrefix(lines[2], "Example: A 2/2") {
+		t.Errorf("702.19b has text %q", r.Text)
+	}
+
+	// headings of chapters are not a part of the rule before them
+	r, _, _ = db.Rule("101.1")
+	if strings.Contains(r.Text, "Additional Rules") {
+		t.Errorf("101.1 has text %q", r.Text)
+	}
+
+	found := db.Search("example: a 6/6", 10)
+	if numbers(found) != "702.19b" {
+		t.Errorf("examples are searched in %q", numbers(found))
+	}
+}
+
+func TestParseGlossary(t *testing.T) {
+	db := testRulesDB(t, readExcerpt(t))
+	doc := db.current()
+	terms := make([]string, 0, len(doc.glossary))
+	for _, e := range doc.glossary {
+		terms = append(terms, e.Term)
+	}
+	// the glossary title of the contents and credits after the glossary are not terms
+	if got := strings.Join(terms, "; "); got != "Abandon; Attacking Creature; Deathtouch" {
+		t.Errorf("glossary terms are %q", got)
+	}
+
+	e, found := db.Glossary(" attacking CREATURE ")
+	if !found {
+		t.Fatal("multiline entry is not found")
+	}
+	want := "A creature that either was declared as an attacker during the declare attackers step or was put onto the battlefield attacking.\nSee rule 506.3."
+	if e.Text != want {
+		t.Errorf("attacking creature is %q, want %q", e.Text, want)
+	}
+	if _, found := db.Glossary("Credits"); found {
+		t.Error("credits are a glossary term")
+	}
+}
+
+func TestParseGlossaryAtEnd(t *testing.T) {
+	text := "100. General\n\n100.1. Rules apply.\n\nGlossary\n\nZone\nA place where objects can be during a game. See rule 400.1."
+	db := testRulesDB(t, text)
+	e, found := db.Glossary("zone")
+	if !found || e.Text != "A place where objects can be during a game. See rule 400.1." {
+		t.Errorf("last entry without an empty line after it is %+v (found %v)", e, found)
+	}
+}
@@ -4,13 +4,15 @@ go 1.13
 
 require (
 	github.com/admirallarimda/tgbotbase v0.0.0-20200131200809-fbd3ee3f4168
-	github.com/go-redis/redis v6.15.7+incompatible // indirect
+	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible // indirect
 	github.com/gocolly/colly v1.2.0
+	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e
 	github.com/ilyalavrinov/mtgbulkbuy v0.0.8
 	github.com/onsi/ginkgo v1.14.0 // indirect
 	github.com/sirupsen/logrus v1.4.2
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
+	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
 	gopkg.in/gcfg.v1 v1.2.3
 	gopkg.in/telegram-bot-api.v4 v4.6.4
 	gopkg.in/warnings.v0 v0.1.2 // indirect
@@ -2,10 +2,16 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"time"
 
 	"github.com/admirallarimda/tgbotbase"
 	"github.com/ilyalavrinov/tgbot-mtg/bot"
+	"github.com/ilyalavrinov/tgbot-mtg/carddb"
+	"github.com/ilyalavrinov/tgbot-mtg/comprules"
+	"golang.org/x/net/proxy"
 	"gopkg.in/gcfg.v1"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,12 +23,45 @@ type config struct {
 	Redis tgbotbase.RedisConfig
 
 	Cards struct {
-		ScryfallDumpDir string
+		ScryfallDumpDir   string
+		ScryfallAPI       string
+		BulkType          string
+		UpdatePeriodHours int
 	}
 
-	Cache struct {
+	Rules struct {
 		Dir string
+		URL string
+	}
+
+	Cache struct {
+		Dir       string
+		MaxSizeMB int
+		MaxCount  int
+		// FileIDDB is the redis db keeping Telegram file ids of uploaded pictures
+		FileIDDB string
+	}
+
+	Admin struct {
+		User []int
+	}
+
+	Prices struct {
+		CacheTTLMinutes int
+		StaleTTLMinutes int
+		RedisDB         string
+		WatchCheckHours int
+		HistoryDB       string
+	}
+
+	Currency struct {
+		RatesURL          string
+		UpdatePeriodHours int
 	}
+
+	Provider map[string]*bot.PriceProviderConfig
+
+	Delivery map[string]*bot.DeliveryConfig
 }
 
 func main() {
@@ -34,23 +73,121 @@ func main() {
 		log.WithFields(log.Fields{"filepath": *argCfg, "error": err}).Fatal("Config parse failed")
 	}
 
-	tgbot := tgbotbase.NewBot(tgbotbase.Config{TGBot: cfg.TGBot, Proxy_SOCKS5: cfg.Proxy_SOCKS5})
 	if cfg.Cache.Dir == "" {
 		cfg.Cache.Dir = "./piccache"
 	}
 	if cfg.Cards.ScryfallDumpDir == "" {
 		cfg.Cards.ScryfallDumpDir = "./scryfall"
 	}
+	if cfg.Cache.FileIDDB == "" {
+		cfg.Cache.FileIDDB = "property"
+	}
+	if cfg.Rules.Dir == "" {
+		cfg.Rules.Dir = "./comprules"
+	}
+	if cfg.Prices.CacheTTLMinutes == 0 {
+		cfg.Prices.CacheTTLMinutes = 60
+	}
+	if cfg.Prices.StaleTTLMinutes == 0 {
+		cfg.Prices.StaleTTLMinutes = 24 * 60
+	}
+	if cfg.Prices.HistoryDB == "" {
+		cfg.Prices.HistoryDB = "property"
+	}
+	if cfg.Prices.WatchCheckHours == 0 {
+		cfg.Prices.WatchCheckHours = 6
+	}
+	if cfg.Currency.UpdatePeriodHours == 0 {
+		cfg.Currency.UpdatePeriodHours = 24
+	}
+	if cfg.Cards.UpdatePeriodHours == 0 {
+		cfg.Cards.UpdatePeriodHours = 24
+	}
+
+	cards := carddb.New(carddb.Config{
+		Dir:      cfg.Cards.ScryfallDumpDir,
+		APIURL:   cfg.Cards.ScryfallAPI,
+		BulkType: cfg.Cards.BulkType,
+	})
+	if err := cards.Load(); err != nil {
+		log.WithFields(log.Fields{"dir": cfg.Cards.ScryfallDumpDir, "error": err}).Fatal("Cards loading failed")
+	}
+
+	// updates are received by bot.Dispatcher instead of tgbotbase.Bot to get inline queries as well
+	var api *tgbotapi.BotAPI
+	if !cfg.TGBot.SkipConnect {
+		var err error
+		if api, err = newBotAPI(cfg.Config); err != nil {
+			log.WithFields(log.Fields{"error": err}).Fatal("Telegram API client creation failed")
+		}
+	}
 
 	cron := tgbotbase.NewCron()
 	pool := tgbotbase.NewRedisPool(cfg.Redis)
 	props := tgbotbase.NewRedisPropertyStorage(pool)
 
-	tgbot.AddHandler(tgbotbase.NewIncomingMessageDealer(bot.NewFindHandler(cfg.Cards.ScryfallDumpDir, bot.NewPicCache(cfg.Cache.Dir))))
-	tgbot.AddHandler(tgbotbase.NewBackgroundMessageDealer(bot.NewMtgsaleDealHandler(cron, props)))
-	tgbot.AddHandler(tgbotbase.NewBackgroundMessageDealer(bot.NewEdhrecCmdrDailyHandler(cron, props)))
+	providers, err := bot.NewPriceProviders(cfg.Provider)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Fatal("Price providers configuration is wrong")
+	}
+	history := bot.NewPriceHistory(pool.GetConnByName(cfg.Prices.HistoryDB))
+	prices := bot.NewPriceCache(bot.PriceCacheConfig{
+		TTL:     time.Duration(cfg.Prices.CacheTTLMinutes) * time.Minute,
+		Stale:   time.Duration(cfg.Prices.StaleTTLMinutes) * time.Minute,
+		RedisDB: cfg.Prices.RedisDB,
+	}, pool, providers, history)
+
+	currencies := bot.NewCurrencies(cfg.Currency.RatesURL)
+	cron.AddJob(time.Now(), bot.NewCurrencyUpdateJob(currencies, time.Duration(cfg.Currency.UpdatePeriodHours)*time.Hour))
+
+	delivery := make(map[string]bot.DeliveryConfig, len(cfg.Delivery))
+	for seller, d := range cfg.Delivery {
+		delivery[seller] = *d
+	}
+
+	updatePeriod := time.Duration(cfg.Cards.UpdatePeriodHours) * time.Hour
+	cron.AddJob(time.Now().Add(updatePeriod), bot.NewDumpUpdateJob(cards, updatePeriod))
+
+	rules := comprules.New(comprules.Config{Dir: cfg.Rules.Dir, URL: cfg.Rules.URL})
+	if err := rules.Load(); err != nil {
+		log.WithFields(log.Fields{"dir": cfg.Rules.Dir, "error": err}).Error("Comprehensive rules loading failed")
+	}
+	cron.AddJob(time.Now().Add(updatePeriod), bot.NewRulesUpdateJob(rules, updatePeriod))
+
+	photos := bot.NewPhotoSender(api, pool.GetConnByName(cfg.Cache.FileIDDB))
+	pics := bot.NewPicCache(bot.PicCacheConfig{
+		Dir:       cfg.Cache.Dir,
+		MaxSizeMB: cfg.Cache.MaxSizeMB,
+		MaxCount:  cfg.Cache.MaxCount,
+	})
+	tgbot := bot.NewDispatcher(api)
+	tgbot.AddMessageHandler(bot.NewFindHandler(cards, pics, props, prices, currencies, rules, photos))
+	tgbot.AddInlineHandler(bot.NewInlineHandler(api, cards, props, photos))
+	tgbot.AddMessageHandler(bot.NewAdminHandler(cfg.Admin.User, pics))
+	tgbot.AddMessageHandler(bot.NewDeckPriceHandler(api, cards, props, prices, currencies, delivery))
+	watchPeriod := time.Duration(cfg.Prices.WatchCheckHours) * time.Hour
+	tgbot.AddMessageHandler(bot.NewPriceWatchHandler(cron, props, cards, prices, currencies, watchPeriod))
+	tgbot.AddMessageHandler(bot.NewPriceHistoryHandler(cards, history))
+	tgbot.AddMessageHandler(bot.NewSubscriptionHandler(props))
+	daily := bot.NewDailyDelivery(cron, props, photos)
+	tgbot.AddBackgroundHandler(daily)
+	tgbot.AddBackgroundHandler(bot.NewMtgsaleDealHandler(cron, props, cards, daily))
+	tgbot.AddBackgroundHandler(bot.NewEdhrecCmdrDailyHandler(cron, props, cards, prices, currencies, daily))
 
 	log.Info("Starting bot")
 	tgbot.Start()
 	log.Info("Stopping bot")
 }
+
+func newBotAPI(cfg tgbotbase.Config) (*tgbotapi.BotAPI, error) {
+	if cfg.Proxy_SOCKS5.Server == "" {
+		return tgbotapi.NewBotAPI(cfg.TGBot.Token)
+	}
+	auth := proxy.Auth{User: cfg.Proxy_SOCKS5.User, Password: cfg.Proxy_SOCKS5.Pass}
+	dialer, err := proxy.SOCKS5("tcp", cfg.Proxy_SOCKS5.Server, &auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &http.Transport{Dial: dialer.Dial}}
+	return tgbotapi.NewBotAPIWithClient(cfg.TGBot.Token, client)
+}